@@ -0,0 +1,312 @@
+// rpcgen generates typed RPC stubs from a Go interface whose methods look
+// like
+//
+//	Method(ctx context.Context, req Request) (Response, error)
+//
+// With -client it writes a client into the interface's package that sends
+// each call through an rpcstub.Caller. With -server it writes a register
+// function for rpc-server that adds every method to its HandlerRegistry.
+// Method names are sent in the AMQP Type property and values are encoded
+// with a codec.
+//
+// Usage from go:generate:
+//
+//	//go:generate go run ../cmd/rpcgen -type MathService -client client_gen.go
+//	//go:generate go run github.com/Ashraful52038/RabbitMq/pkg/cmd/rpcgen -src ../pkg/mathsvc -type MathService -server mathsvc_gen.go
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// service is the interface being generated for
+type service struct {
+	Name       string
+	Package    string // package name of the interface
+	ImportPath string // import path of the interface's package
+	Methods    []method
+	// ServerPackage is the package the server file is generated into
+	ServerPackage string
+}
+
+// method is one RPC; Req and Resp are spelled for the output package
+type method struct {
+	Name      string
+	Req, Resp string
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("rpcgen: ")
+
+	typeName := flag.String("type", "", "interface to generate stubs for (required)")
+	src := flag.String("src", ".", "directory of the package declaring the interface")
+	clientOut := flag.String("client", "", "write the client to this file")
+	serverOut := flag.String("server", "", "write the rpc-server register function to this file")
+	serverPkg := flag.String("server-package", "main", "package name of the server file")
+	flag.Parse()
+
+	if *typeName == "" || (*clientOut == "" && *serverOut == "") {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if *clientOut != "" {
+		svc, err := parseService(*src, *typeName, false)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := generate(*clientOut, clientTemplate, svc); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *serverOut != "" {
+		importPath, err := importPath(*src)
+		if err != nil {
+			log.Fatal(err)
+		}
+		svc, err := parseService(*src, *typeName, true)
+		if err != nil {
+			log.Fatal(err)
+		}
+		svc.ImportPath = importPath
+		svc.ServerPackage = *serverPkg
+		if err := generate(*serverOut, serverTemplate, svc); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// parseService finds interface name in dir. With qualify, the package's
+// types are qualified with its name for output in another package.
+func parseService(dir, name string, qualify bool) (*service, error) {
+	fset := token.NewFileSet()
+	matches, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range matches {
+		if strings.HasSuffix(path, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, path, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		for _, decl := range f.Decls {
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				ts := spec.(*ast.TypeSpec)
+				if ts.Name.Name != name {
+					continue
+				}
+				iface, ok := ts.Type.(*ast.InterfaceType)
+				if !ok {
+					return nil, fmt.Errorf("%s is not an interface", name)
+				}
+				svc := &service{Name: name, Package: f.Name.Name}
+				// the package name, which need not match the import path
+				qualifier := ""
+				if qualify {
+					qualifier = svc.Package
+				}
+				for _, field := range iface.Methods.List {
+					m, err := parseMethod(field, qualifier)
+					if err != nil {
+						return nil, fmt.Errorf("%s: %w", name, err)
+					}
+					svc.Methods = append(svc.Methods, m)
+				}
+				return svc, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("interface %s not found in %s", name, dir)
+}
+
+// parseMethod checks field is Method(context.Context, Req) (Resp, error)
+func parseMethod(field *ast.Field, qualifier string) (method, error) {
+	ft, ok := field.Type.(*ast.FuncType)
+	if !ok || len(field.Names) != 1 {
+		return method{}, errors.New("embedded interfaces are not supported")
+	}
+	name := field.Names[0].Name
+	params := flatten(ft.Params)
+	results := flatten(ft.Results)
+	if len(params) != 2 || !isSelector(params[0], "context", "Context") {
+		return method{}, fmt.Errorf("%s must take (context.Context, Request)", name)
+	}
+	if len(results) != 2 || !isIdent(results[1], "error") {
+		return method{}, fmt.Errorf("%s must return (Response, error)", name)
+	}
+	req, err := typeString(params[1], qualifier)
+	if err != nil {
+		return method{}, fmt.Errorf("%s request: %w", name, err)
+	}
+	resp, err := typeString(results[0], qualifier)
+	if err != nil {
+		return method{}, fmt.Errorf("%s response: %w", name, err)
+	}
+	return method{Name: name, Req: req, Resp: resp}, nil
+}
+
+// flatten lists one type per parameter, expanding "a, b T"
+func flatten(fl *ast.FieldList) []ast.Expr {
+	if fl == nil {
+		return nil
+	}
+	var types []ast.Expr
+	for _, f := range fl.List {
+		n := max(len(f.Names), 1)
+		for i := 0; i < n; i++ {
+			types = append(types, f.Type)
+		}
+	}
+	return types
+}
+
+func isIdent(e ast.Expr, name string) bool {
+	id, ok := e.(*ast.Ident)
+	return ok && id.Name == name
+}
+
+func isSelector(e ast.Expr, pkg, name string) bool {
+	sel, ok := e.(*ast.SelectorExpr)
+	return ok && isIdent(sel.X, pkg) && sel.Sel.Name == name
+}
+
+// typeString spells e, prefixing the package's exported types with
+// qualifier. Types from other packages are not supported.
+func typeString(e ast.Expr, qualifier string) (string, error) {
+	switch e := e.(type) {
+	case *ast.Ident:
+		if qualifier != "" && ast.IsExported(e.Name) {
+			return qualifier + "." + e.Name, nil
+		}
+		return e.Name, nil
+	case *ast.StarExpr:
+		s, err := typeString(e.X, qualifier)
+		return "*" + s, err
+	case *ast.ArrayType:
+		elem, err := typeString(e.Elt, qualifier)
+		if err != nil {
+			return "", err
+		}
+		if e.Len == nil {
+			return "[]" + elem, nil
+		}
+		lit, ok := e.Len.(*ast.BasicLit)
+		if !ok {
+			return "", errors.New("array lengths must be literals")
+		}
+		return "[" + lit.Value + "]" + elem, nil
+	case *ast.MapType:
+		k, err := typeString(e.Key, qualifier)
+		if err != nil {
+			return "", err
+		}
+		v, err := typeString(e.Value, qualifier)
+		return "map[" + k + "]" + v, err
+	case *ast.SelectorExpr:
+		return "", fmt.Errorf("type from another package (%s) is not supported", e.Sel.Name)
+	}
+	return "", fmt.Errorf("unsupported type %T", e)
+}
+
+// importPath asks the go tool for the import path of the package in dir
+func importPath(dir string) (string, error) {
+	cmd := exec.Command("go", "list", "-f", "{{.ImportPath}}", ".")
+	cmd.Dir = dir
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("go list %s: %w", dir, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// generate renders tmpl for svc and writes it to path
+func generate(path string, tmpl *template.Template, svc *service) error {
+	src, err := render(tmpl, svc)
+	if err != nil {
+		return fmt.Errorf("generating %s: %w", path, err)
+	}
+	return os.WriteFile(path, src, 0o644)
+}
+
+// render executes tmpl for svc and gofmts the result
+func render(tmpl *template.Template, svc *service) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, svc); err != nil {
+		return nil, err
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("formatting: %w\n%s", err, buf.Bytes())
+	}
+	return src, nil
+}
+
+var clientTemplate = template.Must(template.New("client").Parse(`// Code generated by rpcgen. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"context"
+
+	"github.com/Ashraful52038/RabbitMq/pkg/codec"
+	"github.com/Ashraful52038/RabbitMq/pkg/rpcstub"
+)
+
+// {{.Name}}Client calls {{.Name}} over RabbitMQ
+type {{.Name}}Client struct {
+	caller rpcstub.Caller
+	codec  codec.Codec
+}
+
+var _ {{.Name}} = (*{{.Name}}Client)(nil)
+
+// New{{.Name}}Client returns a client sending requests through caller,
+// encoded with c
+func New{{.Name}}Client(caller rpcstub.Caller, c codec.Codec) *{{.Name}}Client {
+	return &{{.Name}}Client{caller: caller, codec: c}
+}
+{{range .Methods}}
+// {{.Name}} calls {{$.Name}}.{{.Name}} with the AMQP Type "{{.Name}}"
+func (c *{{$.Name}}Client) {{.Name}}(ctx context.Context, req {{.Req}}) ({{.Resp}}, error) {
+	var resp {{.Resp}}
+	err := rpcstub.Invoke(ctx, c.caller, c.codec, "{{.Name}}", req, &resp)
+	return resp, err
+}
+{{end}}`))
+
+var serverTemplate = template.Must(template.New("server").Parse(`// Code generated by rpcgen. DO NOT EDIT.
+
+package {{.ServerPackage}}
+
+import "{{.ImportPath}}"
+
+// register{{.Name}} adds impl's methods to r under their method names,
+// which clients send in the AMQP Type property
+func register{{.Name}}(r *HandlerRegistry, impl {{.Package}}.{{.Name}}) {
+{{- range .Methods}}
+	r.Register("{{.Name}}", HandlerFunc(impl.{{.Name}}))
+{{- end}}
+}
+`))
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files")
+
+// checkGolden compares src with the golden file at path
+func checkGolden(t *testing.T, path string, src []byte) {
+	t.Helper()
+	if *update {
+		if err := os.WriteFile(path, src, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(src, want) {
+		t.Errorf("generated code differs from %s (run go test -update):\n%s", path, src)
+	}
+}
+
+func TestGolden(t *testing.T) {
+	tests := []struct {
+		name    string
+		tmpl    *template.Template
+		qualify bool
+	}{
+		{"client", clientTemplate, false},
+		{"server", serverTemplate, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc, err := parseService("testdata/kv-store", "Store", tt.qualify)
+			if err != nil {
+				t.Fatal(err)
+			}
+			svc.ImportPath = "example.com/kv-store"
+			svc.ServerPackage = "main"
+			src, err := render(tt.tmpl, svc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkGolden(t, filepath.Join("testdata", tt.name+".golden"), src)
+		})
+	}
+}
+
+// TestMathServiceUpToDate catches a template change without go generate
+func TestMathServiceUpToDate(t *testing.T) {
+	svc, err := parseService("../../mathsvc", "MathService", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := render(clientTemplate, svc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile("../../mathsvc/client_gen.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(src, want) {
+		t.Error("mathsvc/client_gen.go is stale; run go generate ./...")
+	}
+}
+
+func TestParseServiceErrors(t *testing.T) {
+	dir := t.TempDir()
+	src := `package bad
+
+import (
+	"context"
+	"time"
+)
+
+type NotAnInterface struct{}
+
+type NoContext interface {
+	Call(req string) (string, error)
+}
+
+type NoError interface {
+	Call(ctx context.Context, req string) (string, string)
+}
+
+type Foreign interface {
+	Call(ctx context.Context, req time.Duration) (string, error)
+}
+
+type Embedded interface {
+	NoContext
+}
+`
+	if err := os.WriteFile(filepath.Join(dir, "bad.go"), []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]string{
+		"NotAnInterface": "not an interface",
+		"NoContext":      "must take (context.Context, Request)",
+		"NoError":        "must return (Response, error)",
+		"Foreign":        "another package",
+		"Embedded":       "embedded interfaces",
+		"Missing":        "not found",
+	}
+	for name, want := range tests {
+		_, err := parseService(dir, name, true)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: error %v, want %q", name, err, want)
+		}
+	}
+}
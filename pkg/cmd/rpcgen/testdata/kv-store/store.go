@@ -0,0 +1,19 @@
+// Package kvstore is rpcgen's golden-file input. Its directory name is
+// not its package name, so generated code must use the latter.
+package kvstore
+
+import "context"
+
+type Store interface {
+	Get(ctx context.Context, key string) (*Entry, error)
+	Put(ctx context.Context, e Entry) (bool, error)
+	List(ctx context.Context, prefixes []string) (map[string][]Entry, error)
+	Hash(ctx context.Context, keys [4]Key) ([32]byte, error)
+}
+
+type Key string
+
+type Entry struct {
+	Key   Key
+	Value []byte
+}
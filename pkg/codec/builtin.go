@@ -0,0 +1,134 @@
+package codec
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// JSON encodes values with encoding/json
+type JSON struct{}
+
+func (JSON) ContentType() string                { return ContentTypeJSON }
+func (JSON) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (JSON) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+// MsgPack encodes values as MessagePack
+type MsgPack struct{}
+
+func (MsgPack) ContentType() string                { return ContentTypeMsgPack }
+func (MsgPack) Marshal(v any) ([]byte, error)      { return msgpack.Marshal(v) }
+func (MsgPack) Unmarshal(data []byte, v any) error { return msgpack.Unmarshal(data, v) }
+
+// Raw passes bytes through untouched. It marshals []byte and string
+// and unmarshals into *[]byte, *string or *any.
+type Raw struct{}
+
+func (Raw) ContentType() string { return ContentTypeRaw }
+
+func (Raw) Marshal(v any) ([]byte, error) {
+	switch v := v.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	case nil:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("raw codec cannot marshal %T", v)
+}
+
+func (Raw) Unmarshal(data []byte, v any) error {
+	switch v := v.(type) {
+	case *[]byte:
+		*v = append([]byte(nil), data...)
+	case *string:
+		*v = string(data)
+	case *any:
+		*v = append([]byte(nil), data...)
+	default:
+		return fmt.Errorf("raw codec cannot unmarshal into %T", v)
+	}
+	return nil
+}
+
+// Text is the plain text format the tutorials started with: strings,
+// bytes, numbers and booleans in their strconv form.
+type Text struct{}
+
+func (Text) ContentType() string { return ContentTypeText }
+
+func (Text) Marshal(v any) ([]byte, error) {
+	switch v := v.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	case int:
+		return strconv.AppendInt(nil, int64(v), 10), nil
+	case int64:
+		return strconv.AppendInt(nil, v, 10), nil
+	case int32:
+		return strconv.AppendInt(nil, int64(v), 10), nil
+	case uint:
+		return strconv.AppendUint(nil, uint64(v), 10), nil
+	case uint64:
+		return strconv.AppendUint(nil, v, 10), nil
+	case uint32:
+		return strconv.AppendUint(nil, uint64(v), 10), nil
+	case float64:
+		return strconv.AppendFloat(nil, v, 'g', -1, 64), nil
+	case bool:
+		return strconv.AppendBool(nil, v), nil
+	case encoding.TextMarshaler:
+		return v.MarshalText()
+	case fmt.Stringer:
+		return []byte(v.String()), nil
+	case nil:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("text codec cannot marshal %T", v)
+}
+
+func (Text) Unmarshal(data []byte, v any) error {
+	s := string(data)
+	var err error
+	switch v := v.(type) {
+	case *[]byte:
+		*v = append([]byte(nil), data...)
+	case *string:
+		*v = s
+	case *any:
+		*v = s
+	case *int:
+		*v, err = strconv.Atoi(s)
+	case *int64:
+		*v, err = strconv.ParseInt(s, 10, 64)
+	case *int32:
+		var n int64
+		n, err = strconv.ParseInt(s, 10, 32)
+		*v = int32(n)
+	case *uint:
+		var n uint64
+		n, err = strconv.ParseUint(s, 10, 0)
+		*v = uint(n)
+	case *uint64:
+		*v, err = strconv.ParseUint(s, 10, 64)
+	case *uint32:
+		var n uint64
+		n, err = strconv.ParseUint(s, 10, 32)
+		*v = uint32(n)
+	case *float64:
+		*v, err = strconv.ParseFloat(s, 64)
+	case *bool:
+		*v, err = strconv.ParseBool(s)
+	case encoding.TextUnmarshaler:
+		err = v.UnmarshalText(data)
+	default:
+		return fmt.Errorf("text codec cannot unmarshal into %T", v)
+	}
+	return err
+}
@@ -0,0 +1,117 @@
+// Package codec encodes and decodes message bodies based on the AMQP
+// ContentType property.
+package codec
+
+import (
+	"fmt"
+	"mime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Content types of the built-in codecs
+const (
+	ContentTypeJSON    = "application/json"
+	ContentTypeMsgPack = "application/msgpack"
+	ContentTypeRaw     = "application/octet-stream"
+	ContentTypeText    = "text/plain"
+)
+
+// Codec marshals Go values to message bodies and back
+type Codec interface {
+	ContentType() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// UnsupportedError is returned for a content type with no registered codec
+type UnsupportedError struct {
+	ContentType string
+	Supported   []string
+}
+
+func (e *UnsupportedError) Error() string {
+	return fmt.Sprintf("unsupported content type %q (supported: %s)",
+		e.ContentType, strings.Join(e.Supported, ", "))
+}
+
+// Registry looks up codecs by content type
+type Registry struct {
+	mu     sync.RWMutex
+	codecs map[string]Codec
+	// fallback is used for messages without a ContentType
+	fallback string
+}
+
+// NewRegistry creates a registry holding codecs. Messages without a
+// content type are decoded with the first codec.
+func NewRegistry(codecs ...Codec) *Registry {
+	r := &Registry{codecs: make(map[string]Codec)}
+	for _, c := range codecs {
+		r.Register(c)
+	}
+	if len(codecs) > 0 {
+		r.fallback = codecs[0].ContentType()
+	}
+	return r
+}
+
+// Default returns a registry with the raw, text, JSON and MessagePack codecs
+func Default() *Registry {
+	r := NewRegistry(Raw{}, Text{}, JSON{}, MsgPack{})
+	r.Alias("application/x-msgpack", ContentTypeMsgPack)
+	return r
+}
+
+// Register adds c under its content type
+func (r *Registry) Register(c Codec) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.codecs[c.ContentType()] = c
+}
+
+// Alias makes alias resolve to the codec registered for contentType
+func (r *Registry) Alias(alias, contentType string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c, ok := r.codecs[contentType]; ok {
+		r.codecs[alias] = c
+	}
+}
+
+// Lookup returns the codec for contentType. Parameters such as
+// "; charset=utf-8" are ignored and an empty type uses the fallback codec.
+func (r *Registry) Lookup(contentType string) (Codec, error) {
+	name := contentType
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		name = mediaType
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if name == "" {
+		name = r.fallback
+	}
+	if c, ok := r.codecs[strings.ToLower(name)]; ok {
+		return c, nil
+	}
+	return nil, &UnsupportedError{ContentType: contentType, Supported: r.supported()}
+}
+
+// Supported lists the registered content types in order
+func (r *Registry) Supported() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.supported()
+}
+
+func (r *Registry) supported() []string {
+	types := make([]string, 0, len(r.codecs))
+	for t := range r.codecs {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
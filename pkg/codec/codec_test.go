@@ -0,0 +1,175 @@
+package codec
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// upper is a test codec registered under a custom content type
+type upper struct{ Text }
+
+func (upper) ContentType() string { return "text/x-upper" }
+
+func TestRegistryLookup(t *testing.T) {
+	r := Default()
+	r.Register(upper{})
+
+	tests := []struct {
+		contentType string
+		want        string
+	}{
+		{"application/json", ContentTypeJSON},
+		{"application/json; charset=utf-8", ContentTypeJSON},
+		{"Application/JSON", ContentTypeJSON},
+		{"text/plain", ContentTypeText},
+		{"application/x-msgpack", ContentTypeMsgPack},
+		{"text/x-upper", "text/x-upper"},
+		{"", ContentTypeRaw}, // the first codec is the fallback
+	}
+	for _, tt := range tests {
+		c, err := r.Lookup(tt.contentType)
+		if err != nil {
+			t.Errorf("Lookup(%q): %v", tt.contentType, err)
+			continue
+		}
+		if c.ContentType() != tt.want {
+			t.Errorf("Lookup(%q) = %s, want %s", tt.contentType, c.ContentType(), tt.want)
+		}
+	}
+}
+
+func TestRegistryLookupUnsupported(t *testing.T) {
+	r := NewRegistry(JSON{}, Text{})
+
+	_, err := r.Lookup("application/xml")
+	var unsupported *UnsupportedError
+	if !errors.As(err, &unsupported) {
+		t.Fatalf("Lookup(application/xml) error = %v, want *UnsupportedError", err)
+	}
+	if unsupported.ContentType != "application/xml" {
+		t.Errorf("ContentType = %q", unsupported.ContentType)
+	}
+	want := []string{ContentTypeJSON, ContentTypeText}
+	if !reflect.DeepEqual(unsupported.Supported, want) {
+		t.Errorf("Supported = %v, want %v", unsupported.Supported, want)
+	}
+	if !reflect.DeepEqual(r.Supported(), want) {
+		t.Errorf("registry Supported() = %v, want %v", r.Supported(), want)
+	}
+}
+
+func TestRegistryEmpty(t *testing.T) {
+	if _, err := NewRegistry().Lookup(""); err == nil {
+		t.Fatal("empty registry found a fallback codec")
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	type point struct {
+		X, Y int
+		Tags []string
+		At   time.Time
+	}
+	in := point{X: 1, Y: -2, Tags: []string{"a", "b"}, At: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)}
+
+	data, err := JSON{}.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out point
+	if err := (JSON{}).Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("round trip = %+v, want %+v", out, in)
+	}
+}
+
+func TestMsgPackRoundTrip(t *testing.T) {
+	in := map[string]any{"n": int8(7), "s": "seven"}
+	data, err := MsgPack{}.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out map[string]any
+	if err := (MsgPack{}).Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("round trip = %#v, want %#v", out, in)
+	}
+}
+
+func TestTextRoundTrip(t *testing.T) {
+	tests := []struct {
+		in   any
+		out  any // a pointer to a zero value of in's type
+		body string
+	}{
+		{"hello", new(string), "hello"},
+		{[]byte("raw"), new([]byte), "raw"},
+		{42, new(int), "42"},
+		{int64(-9000000000), new(int64), "-9000000000"},
+		{int32(-7), new(int32), "-7"},
+		{uint(7), new(uint), "7"},
+		{uint64(18446744073709551615), new(uint64), "18446744073709551615"},
+		{uint32(7), new(uint32), "7"},
+		{2.5, new(float64), "2.5"},
+		{true, new(bool), "true"},
+		{time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC), new(time.Time), "2026-01-02T03:04:05Z"},
+	}
+	for _, tt := range tests {
+		data, err := Text{}.Marshal(tt.in)
+		if err != nil {
+			t.Errorf("Marshal(%v): %v", tt.in, err)
+			continue
+		}
+		if string(data) != tt.body {
+			t.Errorf("Marshal(%v) = %q, want %q", tt.in, data, tt.body)
+		}
+		if err := (Text{}).Unmarshal(data, tt.out); err != nil {
+			t.Errorf("Unmarshal(%q) into %T: %v", data, tt.out, err)
+			continue
+		}
+		if got := reflect.ValueOf(tt.out).Elem().Interface(); !reflect.DeepEqual(got, tt.in) {
+			t.Errorf("round trip of %v = %v", tt.in, got)
+		}
+	}
+}
+
+func TestTextErrors(t *testing.T) {
+	if _, err := (Text{}).Marshal(struct{}{}); err == nil {
+		t.Error("Marshal(struct{}) succeeded")
+	}
+	var n int
+	if err := (Text{}).Unmarshal([]byte("forty"), &n); err == nil {
+		t.Error("Unmarshal(forty) into int succeeded")
+	}
+	var small int32
+	if err := (Text{}).Unmarshal([]byte("4294967296"), &small); err == nil {
+		t.Error("Unmarshal of an overflowing int32 succeeded")
+	}
+	var m map[string]string
+	if err := (Text{}).Unmarshal([]byte("x"), &m); err == nil {
+		t.Error("Unmarshal into a map succeeded")
+	}
+}
+
+func TestRaw(t *testing.T) {
+	data, err := Raw{}.Marshal("bytes")
+	if err != nil || string(data) != "bytes" {
+		t.Fatalf("Marshal(string) = %q, %v", data, err)
+	}
+	var out any
+	if err := (Raw{}).Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	if b, ok := out.([]byte); !ok || string(b) != "bytes" {
+		t.Fatalf("Unmarshal into *any = %#v", out)
+	}
+	if _, err := (Raw{}).Marshal(1); err == nil {
+		t.Error("Marshal(int) succeeded")
+	}
+}
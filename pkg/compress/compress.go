@@ -0,0 +1,240 @@
+// Package compress compresses message bodies on publish and decompresses
+// them on delivery, using the AMQP ContentEncoding property.
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Supported content encodings
+const (
+	Gzip     = "gzip"
+	Zstd     = "zstd"
+	Identity = "identity"
+)
+
+// DefaultMaxSize caps decompressed bodies at 16 MiB
+const DefaultMaxSize = 16 << 20
+
+var (
+	// ErrTooLarge is returned when a body decompresses past the size limit
+	ErrTooLarge = errors.New("decompressed body exceeds size limit")
+	// ErrUnsupported is returned for an unknown ContentEncoding
+	ErrUnsupported = errors.New("unsupported content encoding")
+)
+
+// Options controls compression on publish
+type Options struct {
+	Encoding  string // Gzip or Zstd, "" disables compression
+	Threshold int    // bodies smaller than this are sent as is
+}
+
+// DefaultOptions gzips bodies of 1 KiB and more. Only publishers whose
+// receivers decompress, via Delivery, should use it: an older or third
+// party consumer reads the compressed bytes as they are.
+var DefaultOptions = Options{Encoding: Gzip, Threshold: 1024}
+
+// EncodingEnv names the environment variable that opts a publisher in to
+// compression
+const EncodingEnv = "COMPRESSION"
+
+// OptionsFromEnv returns DefaultOptions with the encoding named by
+// COMPRESSION ("gzip" or "zstd"). Compression is off when it is unset.
+func OptionsFromEnv() (Options, error) {
+	switch v := strings.ToLower(os.Getenv(EncodingEnv)); v {
+	case "", Identity:
+		return Options{}, nil
+	case Gzip, Zstd:
+		opts := DefaultOptions
+		opts.Encoding = v
+		return opts, nil
+	default:
+		return Options{}, fmt.Errorf("%w: %s=%q", ErrUnsupported, EncodingEnv, v)
+	}
+}
+
+// AcceptEncodingHeader lists, comma separated, the encodings the sender of
+// a request can decode in its reply
+const AcceptEncodingHeader = "x-accept-encoding"
+
+// AcceptAll is the AcceptEncodingHeader value of a receiver that decodes
+// with Delivery
+const AcceptAll = Gzip + ", " + Zstd
+
+// Negotiate returns the options to compress a reply to a request carrying
+// headers with. opts applies if the request accepts its encoding, else the
+// first encoding it accepts is used; a request that accepts none, such as
+// one from a client that predates compression, gets no compression.
+func Negotiate(opts Options, headers amqp.Table) Options {
+	if opts.Encoding == "" || opts.Encoding == Identity {
+		return opts
+	}
+	var accept string
+	switch v := headers[AcceptEncodingHeader].(type) {
+	case string:
+		accept = v
+	case []byte:
+		accept = string(v)
+	}
+
+	first := ""
+	for _, e := range strings.Split(accept, ",") {
+		e = strings.ToLower(strings.TrimSpace(e))
+		if e == opts.Encoding {
+			return opts
+		}
+		if first == "" && (e == Gzip || e == Zstd) {
+			first = e
+		}
+	}
+	if first == "" {
+		return Options{}
+	}
+	opts.Encoding = first
+	return opts
+}
+
+// Stats are process-wide counters for metrics
+var Stats struct {
+	Compressed      atomic.Int64 // bodies compressed
+	BytesIn         atomic.Int64 // size of compressed bodies before compression
+	BytesOut        atomic.Int64 // size of compressed bodies after compression
+	CompressNanos   atomic.Int64
+	Decompressed    atomic.Int64
+	DecompressNanos atomic.Int64
+	Rejected        atomic.Int64 // bodies over the size limit
+}
+
+// BytesSaved returns how many bytes compression has saved so far
+func BytesSaved() int64 {
+	return Stats.BytesIn.Load() - Stats.BytesOut.Load()
+}
+
+// Encode compresses body with opts. Bodies below the threshold, or that
+// don't get smaller, are returned unchanged with an empty encoding.
+func Encode(body []byte, opts Options) ([]byte, string, error) {
+	if opts.Encoding == "" || opts.Encoding == Identity || len(body) < opts.Threshold {
+		return body, "", nil
+	}
+
+	start := time.Now()
+	var buf bytes.Buffer
+	switch opts.Encoding {
+	case Gzip:
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(body); err != nil {
+			return nil, "", err
+		}
+		if err := w.Close(); err != nil {
+			return nil, "", err
+		}
+	case Zstd:
+		w, err := zstd.NewWriter(&buf)
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err := w.Write(body); err != nil {
+			return nil, "", err
+		}
+		if err := w.Close(); err != nil {
+			return nil, "", err
+		}
+	default:
+		return nil, "", fmt.Errorf("%w: %q", ErrUnsupported, opts.Encoding)
+	}
+	Stats.CompressNanos.Add(int64(time.Since(start)))
+
+	if buf.Len() >= len(body) {
+		return body, "", nil
+	}
+	Stats.Compressed.Add(1)
+	Stats.BytesIn.Add(int64(len(body)))
+	Stats.BytesOut.Add(int64(buf.Len()))
+	return buf.Bytes(), opts.Encoding, nil
+}
+
+// Decode decompresses body according to encoding, refusing to produce
+// more than maxSize bytes so a small message cannot expand without bound.
+func Decode(body []byte, encoding string, maxSize int64) ([]byte, error) {
+	if encoding == "" || encoding == Identity {
+		return body, nil
+	}
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+
+	start := time.Now()
+	var r io.Reader
+	switch encoding {
+	case Gzip:
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	case Zstd:
+		zr, err := zstd.NewReader(bytes.NewReader(body), zstd.WithDecoderMaxMemory(uint64(maxSize)))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupported, encoding)
+	}
+
+	out, err := io.ReadAll(io.LimitReader(r, maxSize+1))
+	// zstd refuses frames whose window alone is over the memory limit
+	if errors.Is(err, zstd.ErrWindowSizeExceeded) || errors.Is(err, zstd.ErrDecoderSizeExceeded) {
+		Stats.Rejected.Add(1)
+		return nil, ErrTooLarge
+	}
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(out)) > maxSize {
+		Stats.Rejected.Add(1)
+		return nil, ErrTooLarge
+	}
+	Stats.Decompressed.Add(1)
+	Stats.DecompressNanos.Add(int64(time.Since(start)))
+	return out, nil
+}
+
+// Publishing compresses msg.Body in place and sets ContentEncoding, which
+// receivers undo with Delivery
+func Publishing(msg *amqp.Publishing, opts Options) error {
+	if msg.ContentEncoding != "" {
+		return nil // already encoded by the caller
+	}
+	body, encoding, err := Encode(msg.Body, opts)
+	if err != nil {
+		return err
+	}
+	msg.Body = body
+	msg.ContentEncoding = encoding
+	return nil
+}
+
+// Delivery decompresses d.Body in place and clears ContentEncoding
+func Delivery(d *amqp.Delivery, maxSize int64) error {
+	body, err := Decode(d.Body, d.ContentEncoding, maxSize)
+	if err != nil {
+		return err
+	}
+	d.Body = body
+	d.ContentEncoding = ""
+	return nil
+}
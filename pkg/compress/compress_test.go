@@ -0,0 +1,169 @@
+package compress
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// compressible is a body that shrinks well under both encodings
+var compressible = bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog. "), 100)
+
+func TestRoundTrip(t *testing.T) {
+	for _, encoding := range []string{Gzip, Zstd} {
+		body, got, err := Encode(compressible, Options{Encoding: encoding, Threshold: 1024})
+		if err != nil {
+			t.Fatalf("%s: Encode: %v", encoding, err)
+		}
+		if got != encoding {
+			t.Fatalf("%s: encoding = %q", encoding, got)
+		}
+		if len(body) >= len(compressible) {
+			t.Fatalf("%s: compressed to %d bytes from %d", encoding, len(body), len(compressible))
+		}
+
+		out, err := Decode(body, got, 0)
+		if err != nil {
+			t.Fatalf("%s: Decode: %v", encoding, err)
+		}
+		if !bytes.Equal(out, compressible) {
+			t.Fatalf("%s: round trip changed the body", encoding)
+		}
+	}
+}
+
+func TestEncodeSkips(t *testing.T) {
+	tests := []struct {
+		name string
+		body []byte
+		opts Options
+	}{
+		{"below threshold", compressible[:100], Options{Encoding: Gzip, Threshold: 1024}},
+		{"disabled", compressible, Options{Threshold: 1}},
+		{"identity", compressible, Options{Encoding: Identity, Threshold: 1}},
+		// random-looking data that compression would only grow
+		{"incompressible", []byte("k3#Lq9!zX0"), Options{Encoding: Gzip}},
+	}
+	for _, tt := range tests {
+		body, encoding, err := Encode(tt.body, tt.opts)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if encoding != "" || !bytes.Equal(body, tt.body) {
+			t.Errorf("%s: got encoding %q and %d bytes, want the body unchanged", tt.name, encoding, len(body))
+		}
+	}
+}
+
+func TestDecodeRejectsBombs(t *testing.T) {
+	// 8 MiB of zeros compresses to a few KiB
+	huge := make([]byte, 8<<20)
+	for _, encoding := range []string{Gzip, Zstd} {
+		body, _, err := Encode(huge, Options{Encoding: encoding})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rejected := Stats.Rejected.Load()
+
+		if _, err := Decode(body, encoding, 1<<20); !errors.Is(err, ErrTooLarge) {
+			t.Errorf("%s: Decode of %d bytes into 1 MiB: error %v, want ErrTooLarge", encoding, len(body), err)
+		}
+		if Stats.Rejected.Load() != rejected+1 {
+			t.Errorf("%s: rejected bodies not counted", encoding)
+		}
+		if out, err := Decode(body, encoding, int64(len(huge))); err != nil || len(out) != len(huge) {
+			t.Errorf("%s: Decode at exactly the limit: %d bytes, %v", encoding, len(out), err)
+		}
+	}
+}
+
+func TestUnsupportedEncoding(t *testing.T) {
+	if _, err := Decode([]byte("x"), "br", 0); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Decode(br) error = %v, want ErrUnsupported", err)
+	}
+	if _, _, err := Encode(compressible, Options{Encoding: "br"}); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Encode(br) error = %v, want ErrUnsupported", err)
+	}
+}
+
+func TestCorruptBody(t *testing.T) {
+	for _, encoding := range []string{Gzip, Zstd} {
+		if _, err := Decode([]byte("not compressed"), encoding, 0); err == nil {
+			t.Errorf("%s: Decode of garbage succeeded", encoding)
+		}
+	}
+}
+
+func TestPublishingAndDelivery(t *testing.T) {
+	msg := amqp.Publishing{Body: compressible}
+	if err := Publishing(&msg, DefaultOptions); err != nil {
+		t.Fatal(err)
+	}
+	if msg.ContentEncoding != Gzip {
+		t.Fatalf("ContentEncoding = %q, want %q", msg.ContentEncoding, Gzip)
+	}
+
+	// a body the caller already encoded is left alone
+	encoded := amqp.Publishing{Body: compressible, ContentEncoding: Zstd}
+	if err := Publishing(&encoded, DefaultOptions); err != nil || !bytes.Equal(encoded.Body, compressible) {
+		t.Fatalf("Publishing re-encoded a %s body", Zstd)
+	}
+
+	d := amqp.Delivery{Body: msg.Body, ContentEncoding: msg.ContentEncoding}
+	if err := Delivery(&d, 0); err != nil {
+		t.Fatal(err)
+	}
+	if d.ContentEncoding != "" || !bytes.Equal(d.Body, compressible) {
+		t.Fatalf("Delivery left encoding %q and %d bytes", d.ContentEncoding, len(d.Body))
+	}
+}
+
+func TestOptionsFromEnv(t *testing.T) {
+	tests := []struct {
+		env  string
+		want Options
+		err  bool
+	}{
+		// compression is opt-in
+		{"", Options{}, false},
+		{"identity", Options{}, false},
+		{"gzip", DefaultOptions, false},
+		{"ZSTD", Options{Encoding: Zstd, Threshold: DefaultOptions.Threshold}, false},
+		{"br", Options{}, true},
+	}
+	for _, tt := range tests {
+		t.Setenv(EncodingEnv, tt.env)
+		got, err := OptionsFromEnv()
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("%s=%q: %+v, %v", EncodingEnv, tt.env, got, err)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    Options
+		headers amqp.Table
+		want    string
+	}{
+		{"client predates compression", DefaultOptions, nil, ""},
+		{"preferred encoding accepted", DefaultOptions, amqp.Table{AcceptEncodingHeader: AcceptAll}, Gzip},
+		{"other encoding accepted", DefaultOptions, amqp.Table{AcceptEncodingHeader: "br, ZSTD"}, Zstd},
+		{"nothing supported accepted", DefaultOptions, amqp.Table{AcceptEncodingHeader: "br"}, ""},
+		{"bytes header", DefaultOptions, amqp.Table{AcceptEncodingHeader: []byte("gzip")}, Gzip},
+		{"server compression off", Options{}, amqp.Table{AcceptEncodingHeader: AcceptAll}, ""},
+	}
+	for _, tt := range tests {
+		got := Negotiate(tt.opts, tt.headers)
+		if got.Encoding != tt.want {
+			t.Errorf("%s: encoding %q, want %q", tt.name, got.Encoding, tt.want)
+		}
+		if tt.want != "" && got.Threshold != tt.opts.Threshold {
+			t.Errorf("%s: threshold %d, want %d", tt.name, got.Threshold, tt.opts.Threshold)
+		}
+	}
+}
@@ -0,0 +1,11 @@
+module github.com/Ashraful52038/RabbitMq/pkg
+
+go 1.25.6
+
+require (
+	github.com/klauspost/compress v1.20.1
+	github.com/rabbitmq/amqp091-go v1.10.0
+	github.com/vmihailenco/msgpack/v5 v5.4.1
+)
+
+require github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
@@ -0,0 +1,31 @@
+// Code generated by rpcgen. DO NOT EDIT.
+
+package mathsvc
+
+import (
+	"context"
+
+	"github.com/Ashraful52038/RabbitMq/pkg/codec"
+	"github.com/Ashraful52038/RabbitMq/pkg/rpcstub"
+)
+
+// MathServiceClient calls MathService over RabbitMQ
+type MathServiceClient struct {
+	caller rpcstub.Caller
+	codec  codec.Codec
+}
+
+var _ MathService = (*MathServiceClient)(nil)
+
+// NewMathServiceClient returns a client sending requests through caller,
+// encoded with c
+func NewMathServiceClient(caller rpcstub.Caller, c codec.Codec) *MathServiceClient {
+	return &MathServiceClient{caller: caller, codec: c}
+}
+
+// Fib calls MathService.Fib with the AMQP Type "Fib"
+func (c *MathServiceClient) Fib(ctx context.Context, req FibRequest) (FibResponse, error) {
+	var resp FibResponse
+	err := rpcstub.Invoke(ctx, c.caller, c.codec, "Fib", req, &resp)
+	return resp, err
+}
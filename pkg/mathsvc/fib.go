@@ -0,0 +1,18 @@
+package mathsvc
+
+import "math/big"
+
+// MaxUint64N is the largest n whose Fibonacci number fits in
+// FibResponse.Result. Servers replying with the exact *big.Int, such as
+// the rabbitmq-rpc tutorial server, can accept larger n.
+const MaxUint64N = 93
+
+// Fib returns the exact n-th Fibonacci number, computed iteratively
+func Fib(n int) *big.Int {
+	a, b := big.NewInt(0), big.NewInt(1)
+	for i := 0; i < n; i++ {
+		a.Add(a, b)
+		a, b = b, a
+	}
+	return a
+}
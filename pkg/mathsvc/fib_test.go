@@ -0,0 +1,55 @@
+package mathsvc
+
+import (
+	"math/big"
+	"strconv"
+	"testing"
+)
+
+func TestFib(t *testing.T) {
+	tests := []struct {
+		n    int
+		want string
+	}{
+		{0, "0"},
+		{1, "1"},
+		{2, "1"},
+		{10, "55"},
+		{90, "2880067194370816120"},
+		{92, "7540113804746346429"},
+		{93, "12200160415121876738"}, // first to overflow int64
+		{100, "354224848179261915075"},
+	}
+	for _, tt := range tests {
+		if got := Fib(tt.n).String(); got != tt.want {
+			t.Errorf("Fib(%d) = %s, want %s", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestFibRecurrence(t *testing.T) {
+	prev, cur := Fib(998), Fib(999)
+	next := new(big.Int).Add(prev, cur)
+	if Fib(1000).Cmp(next) != 0 {
+		t.Fatal("Fib(1000) != Fib(998) + Fib(999)")
+	}
+}
+
+func TestMaxUint64N(t *testing.T) {
+	if !Fib(MaxUint64N).IsUint64() {
+		t.Fatalf("Fib(%d) overflows uint64", MaxUint64N)
+	}
+	if Fib(MaxUint64N + 1).IsUint64() {
+		t.Fatalf("Fib(%d) fits in uint64; MaxUint64N is too small", MaxUint64N+1)
+	}
+}
+
+func BenchmarkFib(b *testing.B) {
+	for _, n := range []int{30, 90, 1000, 10000} {
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				Fib(n)
+			}
+		})
+	}
+}
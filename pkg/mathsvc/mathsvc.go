@@ -0,0 +1,24 @@
+// Package mathsvc defines the MathService RPC interface shared by its
+// generated client and rpc-server.
+package mathsvc
+
+import "context"
+
+//go:generate go run ../cmd/rpcgen -type MathService -client client_gen.go
+
+// MathService is served by rpc-server. Each method is called by sending
+// its name in the AMQP Type property.
+type MathService interface {
+	Fib(ctx context.Context, req FibRequest) (FibResponse, error)
+}
+
+// FibRequest asks for the N-th Fibonacci number
+type FibRequest struct {
+	N int `json:"n" msgpack:"n"`
+}
+
+// FibResponse holds fib(N)
+type FibResponse struct {
+	N      int    `json:"n" msgpack:"n"`
+	Result uint64 `json:"result" msgpack:"result"`
+}
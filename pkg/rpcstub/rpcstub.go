@@ -0,0 +1,37 @@
+// Package rpcstub holds the runtime used by clients generated with rpcgen.
+package rpcstub
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Ashraful52038/RabbitMq/pkg/codec"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Caller sends a request and returns its reply. The rabbitmq-rpc
+// rpcclient.Client implements it.
+type Caller interface {
+	Do(ctx context.Context, msg amqp.Publishing) (amqp.Delivery, error)
+}
+
+// Invoke encodes req with c, sends it as method (the AMQP Type property)
+// and decodes the reply into resp
+func Invoke(ctx context.Context, caller Caller, c codec.Codec, method string, req, resp any) error {
+	body, err := c.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("%s: failed to encode request: %w", method, err)
+	}
+	d, err := caller.Do(ctx, amqp.Publishing{
+		Type:        method,
+		ContentType: c.ContentType(),
+		Body:        body,
+	})
+	if err != nil {
+		return err
+	}
+	if err := c.Unmarshal(d.Body, resp); err != nil {
+		return fmt.Errorf("%s: failed to decode response: %w", method, err)
+	}
+	return nil
+}
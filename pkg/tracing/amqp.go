@@ -0,0 +1,80 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Inject writes sc into headers as traceparent and tracestate
+func Inject(headers amqp.Table, sc SpanContext) {
+	if !sc.IsValid() {
+		return
+	}
+	headers[TraceparentHeader] = sc.Traceparent()
+	if sc.TraceState != "" {
+		headers[TracestateHeader] = sc.TraceState
+	} else {
+		delete(headers, TracestateHeader)
+	}
+}
+
+// Extract reads the span context from message headers
+func Extract(headers amqp.Table) (SpanContext, bool) {
+	v, ok := headers[TraceparentHeader].(string)
+	if !ok {
+		return SpanContext{}, false
+	}
+	sc, err := ParseTraceparent(v)
+	if err != nil {
+		return SpanContext{}, false
+	}
+	if ts, ok := headers[TracestateHeader].(string); ok {
+		sc.TraceState = ts
+	}
+	return sc, true
+}
+
+// StartPublish starts a producer span for publishing msg to exchange with
+// routingKey and injects it into msg.Headers. End the span after publishing.
+func (t *Tracer) StartPublish(ctx context.Context, msg *amqp.Publishing, exchange, routingKey string) (context.Context, *Span) {
+	ctx, span := t.Start(ctx, "publish "+destination(exchange, routingKey), KindProducer)
+	span.SetAttribute("messaging.system", "rabbitmq")
+	span.SetAttribute("messaging.destination", exchange)
+	span.SetAttribute("messaging.rabbitmq.routing_key", routingKey)
+	if msg.CorrelationId != "" {
+		span.SetAttribute("messaging.correlation_id", msg.CorrelationId)
+	}
+
+	if msg.Headers == nil {
+		msg.Headers = amqp.Table{}
+	}
+	Inject(msg.Headers, span.Context())
+	return ctx, span
+}
+
+// StartReceive starts a consumer span for d, continuing the trace from
+// its headers if present. The returned context carries the span.
+func (t *Tracer) StartReceive(ctx context.Context, d amqp.Delivery) (context.Context, *Span) {
+	parent, _ := Extract(d.Headers)
+	span := t.StartWithParent(parent, "receive "+destination(d.Exchange, d.RoutingKey), KindConsumer)
+	span.SetAttribute("messaging.system", "rabbitmq")
+	span.SetAttribute("messaging.destination", d.Exchange)
+	span.SetAttribute("messaging.rabbitmq.routing_key", d.RoutingKey)
+	if d.CorrelationId != "" {
+		span.SetAttribute("messaging.correlation_id", d.CorrelationId)
+	}
+	return ContextWithSpan(ctx, span), span
+}
+
+// destination names the target of a message for span names
+func destination(exchange, routingKey string) string {
+	if exchange == "" {
+		return routingKey
+	}
+	if routingKey == "" {
+		return exchange
+	}
+	return fmt.Sprintf("%s/%s", exchange, routingKey)
+}
@@ -0,0 +1,93 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestInjectExtract(t *testing.T) {
+	sc := SpanContext{TraceID: newTraceID(), SpanID: newSpanID(), Flags: FlagSampled, TraceState: "vendor=1"}
+	headers := amqp.Table{}
+	Inject(headers, sc)
+
+	got, ok := Extract(headers)
+	if !ok {
+		t.Fatalf("Extract found nothing in %v", headers)
+	}
+	if got != sc {
+		t.Fatalf("Extract = %+v, want %+v", got, sc)
+	}
+
+	// a span without tracestate clears a stale one
+	sc.TraceState = ""
+	Inject(headers, sc)
+	if _, ok := headers[TracestateHeader]; ok {
+		t.Fatal("Inject left a stale tracestate header")
+	}
+}
+
+func TestInjectInvalid(t *testing.T) {
+	headers := amqp.Table{}
+	Inject(headers, SpanContext{})
+	if len(headers) != 0 {
+		t.Fatalf("Inject of an invalid span set %v", headers)
+	}
+}
+
+func TestExtractMissingOrMalformed(t *testing.T) {
+	for _, headers := range []amqp.Table{
+		nil,
+		{},
+		{TraceparentHeader: "garbage"},
+		{TraceparentHeader: []byte("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")},
+	} {
+		if sc, ok := Extract(headers); ok {
+			t.Errorf("Extract(%v) = %+v, want nothing", headers, sc)
+		}
+	}
+}
+
+func TestPublishReceiveContinuesTrace(t *testing.T) {
+	exp := &recorder{}
+	tracer := NewTracer("test", exp)
+
+	msg := amqp.Publishing{CorrelationId: "corr-1"}
+	_, pub := tracer.StartPublish(context.Background(), &msg, "", "rpc_queue")
+	pub.End()
+
+	d := amqp.Delivery{Headers: msg.Headers, RoutingKey: "rpc_queue", CorrelationId: "corr-1"}
+	ctx, recv := tracer.StartReceive(context.Background(), d)
+	recv.End()
+
+	if SpanFromContext(ctx) != recv {
+		t.Fatal("StartReceive did not put the span in the context")
+	}
+	if len(exp.spans) != 2 {
+		t.Fatalf("exported %d spans, want 2", len(exp.spans))
+	}
+	p, r := exp.spans[0], exp.spans[1]
+	if r.TraceID != p.TraceID || r.ParentID != p.SpanID {
+		t.Fatalf("receive span %s/%s is not a child of publish span %s/%s", r.TraceID, r.ParentID, p.TraceID, p.SpanID)
+	}
+	if p.Name != "publish rpc_queue" || p.Kind != KindProducer || r.Kind != KindConsumer {
+		t.Fatalf("spans %q (%s) and %q (%s)", p.Name, p.Kind, r.Name, r.Kind)
+	}
+	if r.Attributes["messaging.correlation_id"] != "corr-1" {
+		t.Fatalf("receive attributes %v", r.Attributes)
+	}
+}
+
+func TestDestination(t *testing.T) {
+	tests := []struct{ exchange, key, want string }{
+		{"", "rpc_queue", "rpc_queue"},
+		{"logs", "", "logs"},
+		{"topic_logs", "kern.critical", "topic_logs/kern.critical"},
+	}
+	for _, tt := range tests {
+		if got := destination(tt.exchange, tt.key); got != tt.want {
+			t.Errorf("destination(%q, %q) = %q, want %q", tt.exchange, tt.key, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package tracing
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Exporter receives finished spans
+type Exporter interface {
+	Export(span SpanData)
+	Close() error
+}
+
+// NoopExporter discards spans
+type NoopExporter struct{}
+
+func (NoopExporter) Export(SpanData) {}
+func (NoopExporter) Close() error    { return nil }
+
+// JSONExporter writes one JSON object per span, per line
+type JSONExporter struct {
+	mu     sync.Mutex
+	enc    *json.Encoder
+	closer io.Closer
+}
+
+// NewJSONExporter writes spans to w
+func NewJSONExporter(w io.Writer) *JSONExporter {
+	e := &JSONExporter{enc: json.NewEncoder(w)}
+	if c, ok := w.(io.Closer); ok && w != os.Stdout && w != os.Stderr {
+		e.closer = c
+	}
+	return e
+}
+
+// NewFileExporter appends spans to the file at path
+func NewFileExporter(path string) (*JSONExporter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return NewJSONExporter(f), nil
+}
+
+func (e *JSONExporter) Export(span SpanData) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.enc.Encode(span); err != nil {
+		log.Printf("tracing: failed to export span: %v", err)
+	}
+}
+
+func (e *JSONExporter) Close() error {
+	if e.closer != nil {
+		return e.closer.Close()
+	}
+	return nil
+}
+
+// ExporterFromEnv picks an exporter from the TRACE_EXPORTER variable:
+// "stdout", "stderr", "file:<path>", or empty/"none" to discard spans.
+func ExporterFromEnv() (Exporter, error) {
+	v := os.Getenv("TRACE_EXPORTER")
+	switch {
+	case v == "" || v == "none":
+		return NoopExporter{}, nil
+	case v == "stdout":
+		return NewJSONExporter(os.Stdout), nil
+	case v == "stderr":
+		return NewJSONExporter(os.Stderr), nil
+	case strings.HasPrefix(v, "file:"):
+		e, err := NewFileExporter(strings.TrimPrefix(v, "file:"))
+		if err != nil {
+			// not e, a nil *JSONExporter would make a non-nil Exporter
+			return nil, err
+		}
+		return e, nil
+	}
+	return nil, fmt.Errorf("unknown TRACE_EXPORTER %q", v)
+}
+
+// FromEnv creates a tracer for service exporting to TRACE_EXPORTER:
+// "stdout", "stderr" or "file:<path>" write JSON lines, and spans are
+// discarded when it is unset or "none". An invalid setting is logged and
+// spans are discarded rather than failing the program.
+func FromEnv(service string) *Tracer {
+	exporter, err := ExporterFromEnv()
+	if err != nil {
+		log.Printf("tracing disabled: %v", err)
+	}
+	return NewTracer(service, exporter)
+}
@@ -0,0 +1,121 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recorder is an exporter that keeps spans in memory
+type recorder struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func (r *recorder) Export(span SpanData) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, span)
+}
+
+func (r *recorder) Close() error { return nil }
+
+func TestJSONExporter(t *testing.T) {
+	var buf bytes.Buffer
+	tracer := NewTracer("svc", NewJSONExporter(&buf))
+
+	ctx, parent := tracer.Start(context.Background(), "parent", KindServer)
+	_, child := tracer.Start(ctx, "child", KindInternal)
+	child.SetAttribute("k", "v")
+	child.RecordError(errors.New("boom"))
+	child.RecordError(nil)
+	child.End()
+	child.End() // only the first End exports
+	parent.End()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("exported %d lines, want 2:\n%s", len(lines), buf.String())
+	}
+	var c, p SpanData
+	if err := json.Unmarshal([]byte(lines[0]), &c); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &p); err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Service != "svc" || c.Name != "child" || c.Kind != KindInternal {
+		t.Errorf("child span %+v", c)
+	}
+	if c.TraceID != p.TraceID || c.ParentID != p.SpanID || p.ParentID != "" {
+		t.Errorf("child %s/%s under parent %s/%s", c.TraceID, c.ParentID, p.TraceID, p.SpanID)
+	}
+	if c.Attributes["k"] != "v" || c.Error != "boom" {
+		t.Errorf("child attributes %v, error %q", c.Attributes, c.Error)
+	}
+	if c.End.Before(c.Start) || c.Duration == "" {
+		t.Errorf("child timing %s to %s (%s)", c.Start, c.End, c.Duration)
+	}
+
+	// optional fields are left out rather than written empty
+	for _, field := range []string{`"parent_id"`, `"attributes"`, `"error"`, `"tracestate"`} {
+		if strings.Contains(lines[1], field) {
+			t.Errorf("parent line has empty %s: %s", field, lines[1])
+		}
+	}
+}
+
+func TestExporterFromEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.jsonl")
+	tests := []struct {
+		value string
+		ok    bool
+	}{
+		{"", true},
+		{"none", true},
+		{"stdout", true},
+		{"stderr", true},
+		{"file:" + path, true},
+		{"file:" + filepath.Join(path, "not-a-dir", "spans.jsonl"), false},
+		{"jaeger", false},
+	}
+	for _, tt := range tests {
+		t.Setenv("TRACE_EXPORTER", tt.value)
+		e, err := ExporterFromEnv()
+		if (err == nil) != tt.ok {
+			t.Errorf("TRACE_EXPORTER=%q: error %v", tt.value, err)
+			continue
+		}
+		if err != nil && e != nil {
+			t.Errorf("TRACE_EXPORTER=%q: exporter %#v returned with error", tt.value, e)
+			continue
+		}
+		if e != nil {
+			e.Close()
+		}
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("file exporter did not create %s: %v", path, err)
+	}
+}
+
+func TestFromEnvFallsBackToNoop(t *testing.T) {
+	unwritable := "file:" + filepath.Join(t.TempDir(), "missing", "spans.jsonl")
+	for _, value := range []string{"jaeger", unwritable} {
+		t.Setenv("TRACE_EXPORTER", value)
+		tracer := FromEnv("svc")
+		if _, ok := tracer.exporter.(NoopExporter); !ok {
+			t.Fatalf("TRACE_EXPORTER=%q: exporter = %T, want NoopExporter", value, tracer.exporter)
+		}
+		// ending a span must not reach a broken exporter
+		_, span := tracer.Start(context.Background(), "op", KindInternal)
+		span.End()
+	}
+}
@@ -0,0 +1,62 @@
+package tracing
+
+import "testing"
+
+func TestParseTraceparent(t *testing.T) {
+	const (
+		traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		spanID  = "00f067aa0ba902b7"
+	)
+	valid := []struct {
+		in    string
+		flags byte
+	}{
+		{"00-" + traceID + "-" + spanID + "-01", FlagSampled},
+		{"00-" + traceID + "-" + spanID + "-00", 0},
+		{" 00-" + traceID + "-" + spanID + "-01 ", FlagSampled},
+		// later versions may append fields
+		{"01-" + traceID + "-" + spanID + "-01-extra", FlagSampled},
+	}
+	for _, tt := range valid {
+		sc, err := ParseTraceparent(tt.in)
+		if err != nil {
+			t.Errorf("ParseTraceparent(%q): %v", tt.in, err)
+			continue
+		}
+		if sc.TraceID.String() != traceID || sc.SpanID.String() != spanID || sc.Flags != tt.flags {
+			t.Errorf("ParseTraceparent(%q) = %s %s %02x", tt.in, sc.TraceID, sc.SpanID, sc.Flags)
+		}
+	}
+
+	invalid := map[string]string{
+		"empty":            "",
+		"too few fields":   "00-" + traceID + "-" + spanID,
+		"version ff":       "ff-" + traceID + "-" + spanID + "-01",
+		"long version":     "000-" + traceID + "-" + spanID + "-01",
+		"00 with extra":    "00-" + traceID + "-" + spanID + "-01-extra",
+		"short trace id":   "00-" + traceID[1:] + "-" + spanID + "-01",
+		"long span id":     "00-" + traceID + "-" + spanID + "0-01",
+		"long flags":       "00-" + traceID + "-" + spanID + "-001",
+		"upper case":       "00-4BF92F3577B34DA6A3CE929D0E0E4736-" + spanID + "-01",
+		"non-hex trace id": "00-4bf92f3577b34da6a3ce929d0e0e473g-" + spanID + "-01",
+		"non-hex flags":    "00-" + traceID + "-" + spanID + "-0x",
+		"zero trace id":    "00-00000000000000000000000000000000-" + spanID + "-01",
+		"zero span id":     "00-" + traceID + "-0000000000000000-01",
+	}
+	for name, in := range invalid {
+		if sc, err := ParseTraceparent(in); err == nil {
+			t.Errorf("%s: ParseTraceparent(%q) = %+v, want an error", name, in, sc)
+		}
+	}
+}
+
+func TestTraceparentRoundTrip(t *testing.T) {
+	sc := SpanContext{TraceID: newTraceID(), SpanID: newSpanID(), Flags: FlagSampled}
+	got, err := ParseTraceparent(sc.Traceparent())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != sc {
+		t.Fatalf("round trip = %+v, want %+v", got, sc)
+	}
+}
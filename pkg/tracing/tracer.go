@@ -0,0 +1,159 @@
+package tracing
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Span kinds
+const (
+	KindInternal = "internal"
+	KindProducer = "producer"
+	KindConsumer = "consumer"
+	KindClient   = "client"
+	KindServer   = "server"
+)
+
+// SpanData is what exporters receive when a span ends
+type SpanData struct {
+	Service    string            `json:"service"`
+	Name       string            `json:"name"`
+	Kind       string            `json:"kind"`
+	TraceID    string            `json:"trace_id"`
+	SpanID     string            `json:"span_id"`
+	ParentID   string            `json:"parent_id,omitempty"`
+	TraceState string            `json:"tracestate,omitempty"`
+	Start      time.Time         `json:"start"`
+	End        time.Time         `json:"end"`
+	Duration   string            `json:"duration"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Error      string            `json:"error,omitempty"`
+}
+
+// Tracer creates spans for one service and hands them to an exporter
+type Tracer struct {
+	service  string
+	exporter Exporter
+}
+
+// NewTracer creates a tracer; a nil exporter discards spans
+func NewTracer(service string, exporter Exporter) *Tracer {
+	if exporter == nil {
+		exporter = NoopExporter{}
+	}
+	return &Tracer{service: service, exporter: exporter}
+}
+
+// Close flushes and closes the exporter
+func (t *Tracer) Close() error {
+	return t.exporter.Close()
+}
+
+// Span is an operation being timed. It is safe for concurrent use.
+type Span struct {
+	tracer *Tracer
+	sc     SpanContext
+	parent SpanID
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+type spanKey struct{}
+
+// ContextWithSpan returns a copy of ctx carrying span
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext returns the span in ctx, or nil
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// Start begins a span as a child of the span in ctx, or a new trace
+func (t *Tracer) Start(ctx context.Context, name, kind string) (context.Context, *Span) {
+	var parent SpanContext
+	if p := SpanFromContext(ctx); p != nil {
+		parent = p.sc
+	}
+	span := t.StartWithParent(parent, name, kind)
+	return ContextWithSpan(ctx, span), span
+}
+
+// StartWithParent begins a span under a remote parent, such as one
+// extracted from message headers. An invalid parent starts a new trace.
+func (t *Tracer) StartWithParent(parent SpanContext, name, kind string) *Span {
+	sc := SpanContext{SpanID: newSpanID(), Flags: FlagSampled}
+	if parent.IsValid() {
+		sc.TraceID = parent.TraceID
+		sc.Flags = parent.Flags
+		sc.TraceState = parent.TraceState
+	} else {
+		sc.TraceID = newTraceID()
+		parent = SpanContext{}
+	}
+
+	span := &Span{
+		tracer: t,
+		sc:     sc,
+		parent: parent.SpanID,
+		data: SpanData{
+			Service:    t.service,
+			Name:       name,
+			Kind:       kind,
+			TraceID:    sc.TraceID.String(),
+			SpanID:     sc.SpanID.String(),
+			TraceState: sc.TraceState,
+			Start:      time.Now(),
+		},
+	}
+	if parent.SpanID.IsValid() {
+		span.data.ParentID = parent.SpanID.String()
+	}
+	return span
+}
+
+// Context returns the span's propagated context
+func (s *Span) Context() SpanContext {
+	return s.sc
+}
+
+// SetAttribute records a key/value pair on the span
+func (s *Span) SetAttribute(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data.Attributes == nil {
+		s.data.Attributes = make(map[string]string)
+	}
+	s.data.Attributes[key] = value
+}
+
+// RecordError marks the span as failed; nil errors are ignored
+func (s *Span) RecordError(err error) {
+	if err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Error = err.Error()
+}
+
+// End finishes the span and exports it. Only the first call has an effect.
+func (s *Span) End() {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	s.data.Duration = s.data.End.Sub(s.data.Start).String()
+	data := s.data
+	s.mu.Unlock()
+
+	s.tracer.exporter.Export(data)
+}
@@ -7,6 +7,8 @@ import (
 	"strings"
 	"time"
 
+	"github.com/Ashraful52038/RabbitMq/pkg/compress"
+	"github.com/Ashraful52038/RabbitMq/pkg/tracing"
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
@@ -35,6 +37,9 @@ func main() {
 	failOnError(err, "Failed to open a channel")
 	defer ch.Close()
 
+	tracer := tracing.FromEnv("emit_log")
+	defer tracer.Close()
+
 	// Exchange declare (fanout type)
 	err = ch.ExchangeDeclare(
 		"logs",   // name
@@ -52,15 +57,24 @@ func main() {
 	defer cancel()
 
 	body := bodyFrom(os.Args)
+	msg := amqp.Publishing{
+		ContentType: "text/plain",
+		Body:        []byte(body),
+	}
+	opts, err := compress.OptionsFromEnv()
+	failOnError(err, "Invalid compression")
+	err = compress.Publishing(&msg, opts)
+	failOnError(err, "Failed to compress message")
+
+	_, span := tracer.StartPublish(ctx, &msg, "logs", "")
 	err = ch.PublishWithContext(ctx,
 		"logs", // exchange
 		"",     // routing key (fanout exchange এ ignored)
 		false,  // mandatory
 		false,  // immediate
-		amqp.Publishing{
-			ContentType: "text/plain",
-			Body:        []byte(body),
-		})
+		msg)
+	span.RecordError(err)
+	span.End()
 	failOnError(err, "Failed to publish a message")
 
 	log.Printf(" [x] Sent %s", body)
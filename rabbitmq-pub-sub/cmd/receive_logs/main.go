@@ -1,8 +1,11 @@
 package main
 
 import (
+	"context"
 	"log"
 
+	"github.com/Ashraful52038/RabbitMq/pkg/compress"
+	"github.com/Ashraful52038/RabbitMq/pkg/tracing"
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
@@ -21,6 +24,9 @@ func main() {
 	failOnError(err, "Failed to open a channel")
 	defer ch.Close()
 
+	tracer := tracing.FromEnv("receive_logs")
+	defer tracer.Close()
+
 	// Exchange declare
 	err = ch.ExchangeDeclare(
 		"logs",   // name
@@ -71,7 +77,15 @@ func main() {
 
 	go func() {
 		for d := range msgs {
+			_, span := tracer.StartReceive(context.Background(), d)
+			if err := compress.Delivery(&d, compress.DefaultMaxSize); err != nil {
+				log.Printf(" [!] Dropped message: %s", err)
+				span.RecordError(err)
+				span.End()
+				continue
+			}
 			log.Printf(" [x] %s", d.Body)
+			span.End()
 		}
 	}()
 
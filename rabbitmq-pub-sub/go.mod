@@ -2,4 +2,11 @@ module rabbitmq-pub-sub
 
 go 1.25.6
 
-require github.com/rabbitmq/amqp091-go v1.10.0
+require (
+	github.com/Ashraful52038/RabbitMq/pkg v0.0.0
+	github.com/rabbitmq/amqp091-go v1.10.0
+)
+
+require github.com/klauspost/compress v1.20.1 // indirect
+
+replace github.com/Ashraful52038/RabbitMq/pkg => ../pkg
@@ -7,6 +7,8 @@ import (
 	"strings"
 	"time"
 
+	"github.com/Ashraful52038/RabbitMq/pkg/compress"
+	"github.com/Ashraful52038/RabbitMq/pkg/tracing"
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
@@ -46,6 +48,9 @@ func main() {
 	failOnError(err, "Failed to open a channel")
 	defer ch.Close()
 
+	tracer := tracing.FromEnv("emit_log_direct")
+	defer tracer.Close()
+
 	// Direct Exchange declare
 	err = ch.ExchangeDeclare(
 		"logs_direct", // name
@@ -65,15 +70,24 @@ func main() {
 	severity := severityFrom(os.Args)
 	body := bodyFrom(os.Args)
 
+	msg := amqp.Publishing{
+		ContentType: "text/plain",
+		Body:        []byte(body),
+	}
+	opts, err := compress.OptionsFromEnv()
+	failOnError(err, "Invalid compression")
+	err = compress.Publishing(&msg, opts)
+	failOnError(err, "Failed to compress message")
+
+	_, span := tracer.StartPublish(ctx, &msg, "logs_direct", severity)
 	err = ch.PublishWithContext(ctx,
 		"logs_direct", // exchange
 		severity,      // routing key (severity)
 		false,         // mandatory
 		false,         // immediate
-		amqp.Publishing{
-			ContentType: "text/plain",
-			Body:        []byte(body),
-		})
+		msg)
+	span.RecordError(err)
+	span.End()
 	failOnError(err, "Failed to publish a message")
 
 	log.Printf(" [x] Sent %s: %s", severity, body)
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"rabbitmq-rpc/rpcclient"
+)
+
+// benchReport summarises a benchmark run
+type benchReport struct {
+	Calls        int            `json:"calls"`
+	Concurrency  int            `json:"concurrency"`
+	OK           int            `json:"ok"`
+	Errors       int            `json:"errors"`
+	ErrorsByKind map[string]int `json:"errors_by_kind"`
+	Seconds      float64        `json:"duration_seconds"`
+	Throughput   float64        `json:"throughput_per_second"`
+	// Latency percentiles of successful calls, in milliseconds
+	Latency struct {
+		P50 float64 `json:"p50"`
+		P90 float64 `json:"p90"`
+		P99 float64 `json:"p99"`
+		Max float64 `json:"max"`
+	} `json:"latency_ms"`
+}
+
+// caller is the part of *rpcclient.Client a benchmark uses
+type caller interface {
+	Call(ctx context.Context, msg amqp.Publishing) (amqp.Delivery, error)
+}
+
+// runBench sends msg n times with at most concurrency calls in flight
+func runBench(client caller, msg amqp.Publishing, n, concurrency int, timeout time.Duration) *benchReport {
+	concurrency = max(1, min(concurrency, n))
+	report := &benchReport{Calls: n, Concurrency: concurrency, ErrorsByKind: make(map[string]int)}
+
+	var mu sync.Mutex
+	latencies := make([]time.Duration, 0, n)
+	jobs := make(chan struct{}, n)
+	for i := 0; i < n; i++ {
+		jobs <- struct{}{}
+	}
+	close(jobs)
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range jobs {
+				ctx, cancel := context.WithTimeout(context.Background(), timeout)
+				t := time.Now()
+				_, err := client.Call(ctx, msg)
+				elapsed := time.Since(t)
+				cancel()
+
+				mu.Lock()
+				if err != nil {
+					report.Errors++
+					report.ErrorsByKind[errorKind(err)]++
+				} else {
+					report.OK++
+					latencies = append(latencies, elapsed)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	report.summarize(latencies, time.Since(start))
+	return report
+}
+
+// summarize fills in the throughput and latency percentiles of a run
+// that took elapsed, given the latencies of its successful calls
+func (r *benchReport) summarize(latencies []time.Duration, elapsed time.Duration) {
+	r.Seconds = elapsed.Seconds()
+	if elapsed > 0 {
+		r.Throughput = float64(r.Calls) / elapsed.Seconds()
+	}
+	slices.Sort(latencies)
+	r.Latency.P50 = millis(percentile(latencies, 0.50))
+	r.Latency.P90 = millis(percentile(latencies, 0.90))
+	r.Latency.P99 = millis(percentile(latencies, 0.99))
+	r.Latency.Max = millis(percentile(latencies, 1))
+}
+
+// percentile returns the p-th value of sorted latencies (nearest rank)
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	return sorted[max(i, 0)]
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// errorKind groups call errors for the breakdown
+func errorKind(err error) string {
+	var se *rpcclient.ServerError
+	switch {
+	case errors.As(err, &se):
+		return se.Code
+	case errors.Is(err, rpcclient.ErrTimeout):
+		return "timeout"
+	case errors.Is(err, rpcclient.ErrConnectionLost):
+		return "connection_lost"
+	case errors.Is(err, rpcclient.ErrClosed):
+		return "closed"
+	}
+	return "other"
+}
+
+// WriteText prints the report for humans
+func (r *benchReport) WriteText(w io.Writer) error {
+	_, err := fmt.Fprintf(w, `calls:       %d (concurrency %d)
+ok:          %d
+errors:      %d
+duration:    %.3fs
+throughput:  %.1f calls/s
+latency:     p50 %.2fms  p90 %.2fms  p99 %.2fms  max %.2fms
+`, r.Calls, r.Concurrency, r.OK, r.Errors, r.Seconds, r.Throughput,
+		r.Latency.P50, r.Latency.P90, r.Latency.P99, r.Latency.Max)
+	if err != nil {
+		return err
+	}
+
+	kinds := make([]string, 0, len(r.ErrorsByKind))
+	for kind := range r.ErrorsByKind {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		if _, err := fmt.Fprintf(w, "  %-20s %d\n", kind, r.ErrorsByKind[kind]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeBench prints report as text or JSON. A run with failed calls is
+// an error, so the program exits non-zero.
+func writeBench(w io.Writer, report *benchReport, asJSON bool) error {
+	var err error
+	if asJSON {
+		err = report.WriteJSON(w)
+	} else {
+		err = report.WriteText(w)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	if report.Errors > 0 {
+		return fmt.Errorf("%d of %d calls failed", report.Errors, report.Calls)
+	}
+	return nil
+}
+
+// WriteJSON prints the report as one JSON object
+func (r *benchReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
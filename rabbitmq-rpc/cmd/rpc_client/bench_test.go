@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"rabbitmq-rpc/rpcclient"
+)
+
+func TestPercentile(t *testing.T) {
+	ms := func(n ...int) []time.Duration {
+		out := make([]time.Duration, len(n))
+		for i, v := range n {
+			out[i] = time.Duration(v) * time.Millisecond
+		}
+		return out
+	}
+	tests := []struct {
+		sorted []time.Duration
+		p      float64
+		want   time.Duration
+	}{
+		{nil, 0.5, 0},
+		{ms(7), 0.5, 7 * time.Millisecond},
+		{ms(7), 1, 7 * time.Millisecond},
+		{ms(1, 2, 3, 4), 0.5, 2 * time.Millisecond},
+		{ms(1, 2, 3, 4), 0.51, 3 * time.Millisecond},
+		{ms(1, 2, 3, 4), 0, 1 * time.Millisecond},
+		{ms(1, 2, 3, 4, 5, 6, 7, 8, 9, 10), 0.9, 9 * time.Millisecond},
+		{ms(1, 2, 3, 4, 5, 6, 7, 8, 9, 10), 0.99, 10 * time.Millisecond},
+		{ms(1, 2, 3, 4, 5, 6, 7, 8, 9, 10), 1, 10 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		if got := percentile(tt.sorted, tt.p); got != tt.want {
+			t.Errorf("percentile(%v, %v) = %v, want %v", tt.sorted, tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	r := &benchReport{Calls: 200}
+	latencies := make([]time.Duration, 100)
+	for i := range latencies {
+		// 100ms down to 1ms, so summarize has to sort
+		latencies[i] = time.Duration(100-i) * time.Millisecond
+	}
+	r.summarize(latencies, 2*time.Second)
+
+	if r.Seconds != 2 || r.Throughput != 100 {
+		t.Errorf("%v seconds at %v calls/s, want 2 at 100", r.Seconds, r.Throughput)
+	}
+	if r.Latency.P50 != 50 || r.Latency.P90 != 90 || r.Latency.P99 != 99 || r.Latency.Max != 100 {
+		t.Errorf("latency = %+v, want p50 50 p90 90 p99 99 max 100", r.Latency)
+	}
+
+	// a run where every call failed has no latencies
+	empty := &benchReport{Calls: 3}
+	empty.summarize(nil, time.Second)
+	if empty.Latency.Max != 0 || empty.Throughput != 3 {
+		t.Errorf("empty run: %+v", empty)
+	}
+}
+
+func TestErrorKind(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{&rpcclient.ServerError{Code: "rate_limited"}, "rate_limited"},
+		{fmt.Errorf("call: %w", rpcclient.ErrTimeout), "timeout"},
+		{rpcclient.ErrConnectionLost, "connection_lost"},
+		{rpcclient.ErrClosed, "closed"},
+		{errors.New("boom"), "other"},
+	}
+	for _, tt := range tests {
+		if got := errorKind(tt.err); got != tt.want {
+			t.Errorf("errorKind(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+// fakeCaller fails every third call and tracks the calls in flight
+type fakeCaller struct {
+	mu                    sync.Mutex
+	calls, inflight, peak int
+}
+
+func (f *fakeCaller) Call(ctx context.Context, msg amqp.Publishing) (amqp.Delivery, error) {
+	f.mu.Lock()
+	f.calls++
+	n := f.calls
+	f.inflight++
+	f.peak = max(f.peak, f.inflight)
+	f.mu.Unlock()
+
+	time.Sleep(time.Millisecond)
+
+	f.mu.Lock()
+	f.inflight--
+	f.mu.Unlock()
+	if n%3 == 0 {
+		return amqp.Delivery{}, &rpcclient.ServerError{Code: "handler_error"}
+	}
+	return amqp.Delivery{Body: msg.Body}, nil
+}
+
+func TestRunBench(t *testing.T) {
+	f := &fakeCaller{}
+	r := runBench(f, amqp.Publishing{Body: []byte("30")}, 30, 4, time.Second)
+
+	if f.calls != 30 || r.Calls != 30 || r.OK != 20 || r.Errors != 10 {
+		t.Fatalf("%d calls made; report %d calls, %d ok, %d errors", f.calls, r.Calls, r.OK, r.Errors)
+	}
+	if r.ErrorsByKind["handler_error"] != 10 {
+		t.Fatalf("errors by kind = %v", r.ErrorsByKind)
+	}
+	if f.peak > 4 {
+		t.Fatalf("%d calls in flight, concurrency is 4", f.peak)
+	}
+	if r.Latency.P50 < 1 || r.Latency.Max < r.Latency.P99 || r.Throughput <= 0 {
+		t.Fatalf("summary %+v", r)
+	}
+
+	// concurrency never exceeds the calls to make
+	if r := runBench(&fakeCaller{}, amqp.Publishing{}, 2, 10, time.Second); r.Concurrency != 2 {
+		t.Fatalf("concurrency = %d, want 2", r.Concurrency)
+	}
+}
+
+func TestWriteBench(t *testing.T) {
+	r := &benchReport{Calls: 4, Concurrency: 2, OK: 3, Errors: 1,
+		ErrorsByKind: map[string]int{"timeout": 1}}
+	r.summarize([]time.Duration{time.Millisecond, 2 * time.Millisecond, 3 * time.Millisecond}, time.Second)
+
+	var text bytes.Buffer
+	err := writeBench(&text, r, false)
+	if err == nil || err.Error() != "1 of 4 calls failed" {
+		t.Fatalf("writeBench error = %v, want the failed calls", err)
+	}
+	for _, want := range []string{"calls:       4 (concurrency 2)", "p50 2.00ms", "max 3.00ms", "  timeout"} {
+		if !strings.Contains(text.String(), want) {
+			t.Errorf("text report lacks %q:\n%s", want, text.String())
+		}
+	}
+
+	r.Errors, r.ErrorsByKind = 0, map[string]int{}
+	var out bytes.Buffer
+	if err := writeBench(&out, r, true); err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct {
+		Calls   int `json:"calls"`
+		Latency struct {
+			P90 float64 `json:"p90"`
+		} `json:"latency_ms"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Calls != 4 || decoded.Latency.P90 != 3 {
+		t.Fatalf("JSON report = %s", out.String())
+	}
+}
@@ -0,0 +1,16 @@
+package main
+
+import "fmt"
+
+// defaultMaxN caps n so one request can't tie up the consumer; fib(10000)
+// has 2090 digits and takes well under a millisecond. Replies carry the
+// exact number, so unlike mathsvc.FibResponse there is no uint64 limit.
+const defaultMaxN = 10000
+
+// validateN rejects n outside 0..maxN
+func validateN(n, maxN int) error {
+	if n < 0 || n > maxN {
+		return fmt.Errorf("n must be between 0 and %d, got %d", maxN, n)
+	}
+	return nil
+}
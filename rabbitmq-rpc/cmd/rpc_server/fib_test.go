@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/Ashraful52038/RabbitMq/pkg/mathsvc"
+)
+
+func TestDefaultMaxN(t *testing.T) {
+	if digits := len(mathsvc.Fib(defaultMaxN).String()); digits != 2090 {
+		t.Fatalf("fib(%d) has %d digits, want 2090", defaultMaxN, digits)
+	}
+}
+
+func TestValidateN(t *testing.T) {
+	for _, n := range []int{0, 1, 500, defaultMaxN} {
+		if err := validateN(n, defaultMaxN); err != nil {
+			t.Errorf("validateN(%d) = %v", n, err)
+		}
+	}
+	for _, n := range []int{-1, -100, defaultMaxN + 1} {
+		if err := validateN(n, defaultMaxN); err == nil {
+			t.Errorf("validateN(%d) accepted", n)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Ashraful52038/RabbitMq/pkg/codec"
+	"github.com/Ashraful52038/RabbitMq/pkg/compress"
+	"github.com/Ashraful52038/RabbitMq/pkg/mathsvc"
+	"github.com/Ashraful52038/RabbitMq/pkg/tracing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// publisher is the part of *amqp.Channel the server replies through
+type publisher interface {
+	PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+}
+
+// server answers fib requests
+type server struct {
+	ch     publisher
+	codecs *codec.Registry
+	maxN   int
+	// timeout bounds each request, starting when it is delivered
+	timeout time.Duration
+}
+
+// serve handles deliveries on workers goroutines until msgs closes. The
+// channel's prefetch should match workers so each one has a request.
+func (s *server) serve(msgs <-chan amqp.Delivery, workers int) {
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for d := range msgs {
+				s.handle(d)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// handle answers one request under its own timeout and acks it
+func (s *server) handle(d amqp.Delivery) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	// receive span, continuing the client's trace from the headers
+	ctx, span := tracer.StartReceive(ctx, d)
+	defer span.End()
+
+	// decompress (ContentEncoding), refusing compression bombs
+	if err := compress.Delivery(&d, compress.DefaultMaxSize); err != nil {
+		log.Printf(" [!] %s", err)
+		s.reject(ctx, d, "bad_content_encoding", err.Error(), nil)
+		return
+	}
+
+	// codec from the request content type (text/plain, json, msgpack ...)
+	c, err := s.codecs.Lookup(d.ContentType)
+	if err != nil {
+		var unsupported *codec.UnsupportedError
+		errors.As(err, &unsupported)
+		log.Printf(" [!] %s", err)
+		s.reject(ctx, d, "unsupported_content_type", err.Error(), amqp.Table{
+			"x-supported-content-types": strings.Join(unsupported.Supported, ", "),
+		})
+		return
+	}
+
+	// request to digit
+	var n int
+	if err := c.Unmarshal(d.Body, &n); err != nil {
+		log.Printf(" [!] bad request: %s", err)
+		s.reject(ctx, d, "bad_request", "bad request: "+err.Error(), nil)
+		return
+	}
+	if err := validateN(n, s.maxN); err != nil {
+		log.Printf(" [!] bad request: %s", err)
+		s.reject(ctx, d, "bad_request", "bad request: "+err.Error(), nil)
+		return
+	}
+
+	if stream, _ := d.Headers[streamHeader].(bool); stream {
+		// n+1 items and the end marker must fit the client's buffer, which
+		// drops the whole stream when it overflows
+		if window, ok := streamWindow(d); ok && int64(n)+2 > window {
+			log.Printf(" [!] bad request: stream of %d replies exceeds window %d", n+2, window)
+			s.reject(ctx, d, "bad_request", fmt.Sprintf(
+				"bad request: fib(0..%d) takes %d replies, over the client's stream window of %d", n, n+2, window), nil)
+			return
+		}
+		s.stream(ctx, d, c, n)
+		return
+	}
+
+	log.Printf(" [.] fib(%d)", n)
+	_, handleSpan := tracer.Start(ctx, "handle fib", tracing.KindServer)
+	handleSpan.SetAttribute("fib.n", strconv.Itoa(n))
+	body, err := recoverCall(func() ([]byte, error) {
+		return c.Marshal(mathsvc.Fib(n))
+	})
+	handleSpan.RecordError(err)
+	handleSpan.End()
+
+	var perr *panicError
+	if errors.As(err, &perr) {
+		// poison request: answer, then dead-letter (dropped without a DLX) instead of redelivering
+		log.Printf(" [!] panic handling %s: %v\n%s", d.CorrelationId, perr.value, perr.stack)
+		if err := s.sendError(ctx, d, "internal_error", "internal error", nil); err != nil {
+			log.Printf(" [!] Failed to publish a message: %s", err)
+		}
+		d.Nack(false, false)
+		return
+	}
+	if err != nil {
+		// e.g. the raw codec can't encode a number
+		log.Printf(" [!] failed to encode response: %s", err)
+		s.reject(ctx, d, "handler_error", "failed to encode response: "+err.Error(), nil)
+		return
+	}
+
+	// answer in  clients llback queue (same content type as request)
+	err = s.publishReply(ctx, d, amqp.Publishing{ContentType: c.ContentType(), Body: body})
+	if err != nil {
+		log.Printf(" [!] Failed to publish a message: %s", err)
+	}
+
+	// manual acknowledgment
+	d.Ack(false)
+}
+
+// stream answers a streaming request with fib(0) to fib(n), one reply
+// each numbered in seqHeader, followed by an end-of-stream marker
+func (s *server) stream(ctx context.Context, d amqp.Delivery, c codec.Codec, n int) {
+	log.Printf(" [.] fib(0..%d)", n)
+	_, span := tracer.Start(ctx, "stream fib", tracing.KindServer)
+	span.SetAttribute("fib.n", strconv.Itoa(n))
+	defer span.End()
+
+	a, b := big.NewInt(0), big.NewInt(1)
+	for i := 0; i <= n; i++ {
+		body, err := c.Marshal(a)
+		if err != nil {
+			span.RecordError(err)
+			s.reject(ctx, d, "handler_error", "failed to encode response: "+err.Error(), nil)
+			return
+		}
+		err = s.publishReply(ctx, d, amqp.Publishing{
+			ContentType: c.ContentType(),
+			Headers:     amqp.Table{seqHeader: int64(i)},
+			Body:        body,
+		})
+		if err != nil {
+			// the client will see a gap or time out; nothing more to send
+			span.RecordError(err)
+			log.Printf(" [!] Failed to publish a message: %s", err)
+			d.Ack(false)
+			return
+		}
+		a.Add(a, b)
+		a, b = b, a
+	}
+
+	err := s.publishReply(ctx, d, amqp.Publishing{
+		Headers: amqp.Table{seqHeader: int64(n + 1), streamEndHeader: true},
+	})
+	if err != nil {
+		span.RecordError(err)
+		log.Printf(" [!] Failed to publish a message: %s", err)
+	}
+	d.Ack(false)
+}
+
+// streamWindow reads the streamWindowHeader of a streaming request.
+// Clients that predate it send none and get the whole stream.
+func streamWindow(d amqp.Delivery) (int64, bool) {
+	switch v := d.Headers[streamWindowHeader].(type) {
+	case int64:
+		return v, true
+	case int32:
+		return int64(v), true
+	case int:
+		return int64(v), true
+	}
+	return 0, false
+}
+
+// publishReply sends reply to d's reply queue, compressed if d accepts
+// a compressed reply
+func (s *server) publishReply(ctx context.Context, d amqp.Delivery, reply amqp.Publishing) error {
+	reply.CorrelationId = d.CorrelationId // same correlation ID
+	body := reply.Body
+	if err := compress.Publishing(&reply, compress.Negotiate(compress.DefaultOptions, d.Headers)); err != nil {
+		log.Printf(" [!] Failed to compress response: %s", err)
+		reply.Body, reply.ContentEncoding = body, ""
+	}
+
+	_, span := tracer.StartPublish(ctx, &reply, "", d.ReplyTo)
+	defer span.End()
+	err := s.ch.PublishWithContext(ctx,
+		"",        // exchange
+		d.ReplyTo, // routing key (callback queue)
+		false,     // mandatory
+		false,     // immediate
+		reply)
+	span.RecordError(err)
+	return err
+}
+
+// reject answers d with an error reply and acks it
+func (s *server) reject(ctx context.Context, d amqp.Delivery, code, msg string, headers amqp.Table) {
+	if err := s.sendError(ctx, d, code, msg, headers); err != nil {
+		log.Printf(" [!] Failed to publish a message: %s", err)
+	}
+	d.Ack(false)
+}
+
+// sendError answers d with an error reply carrying code in errorCodeHeader
+func (s *server) sendError(ctx context.Context, d amqp.Delivery, code, msg string, headers amqp.Table) error {
+	if headers == nil {
+		headers = amqp.Table{}
+	}
+	headers[errorCodeHeader] = code
+	reply := amqp.Publishing{
+		ContentType:   codec.ContentTypeText,
+		CorrelationId: d.CorrelationId,
+		Headers:       headers,
+		Body:          []byte(msg),
+	}
+	if span := tracing.SpanFromContext(ctx); span != nil {
+		span.RecordError(fmt.Errorf("%s: %s", code, msg))
+	}
+
+	_, span := tracer.StartPublish(ctx, &reply, "", d.ReplyTo)
+	defer span.End()
+	err := s.ch.PublishWithContext(ctx, "", d.ReplyTo, false, false, reply)
+	span.RecordError(err)
+	return err
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Ashraful52038/RabbitMq/pkg/codec"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// fakePublisher records replies and fails like a real channel once the
+// publish context is done
+type fakePublisher struct {
+	mu      sync.Mutex
+	replies []amqp.Publishing
+	errs    []error
+	// block, if set, is called before each publish
+	block func()
+}
+
+func (f *fakePublisher) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	if f.block != nil {
+		f.block()
+	}
+	err := ctx.Err()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.replies = append(f.replies, msg)
+	f.errs = append(f.errs, err)
+	return err
+}
+
+func (f *fakePublisher) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.replies)
+}
+
+// acks counts acknowledgements by delivery tag
+type acks struct {
+	mu    sync.Mutex
+	acked map[uint64]bool
+}
+
+func (a *acks) Ack(tag uint64, multiple bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.acked[tag] = true
+	return nil
+}
+func (a *acks) Nack(tag uint64, multiple, requeue bool) error { return nil }
+func (a *acks) Reject(tag uint64, requeue bool) error         { return nil }
+
+func (a *acks) isAcked(tag uint64) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.acked[tag]
+}
+
+func request(a *acks, tag uint64, body string) amqp.Delivery {
+	return amqp.Delivery{
+		Acknowledger:  a,
+		DeliveryTag:   tag,
+		ContentType:   codec.ContentTypeText,
+		CorrelationId: body,
+		ReplyTo:       "amq.gen-test",
+		Body:          []byte(body),
+	}
+}
+
+// waitFor polls cond for up to a second
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestServerAnswersAfterTimeoutElapsed(t *testing.T) {
+	pub := &fakePublisher{}
+	srv := &server{ch: pub, codecs: codec.Default(), maxN: defaultMaxN, timeout: 50 * time.Millisecond}
+	msgs := make(chan amqp.Delivery)
+	go srv.serve(msgs, 2)
+	defer close(msgs)
+
+	a := &acks{acked: make(map[uint64]bool)}
+	msgs <- request(a, 1, "10")
+	waitFor(t, "first reply", func() bool { return pub.count() == 1 })
+
+	// the server has now been running for longer than the request timeout
+	time.Sleep(2 * srv.timeout)
+
+	msgs <- request(a, 2, "20")
+	waitFor(t, "second reply", func() bool { return pub.count() == 2 })
+
+	pub.mu.Lock()
+	defer pub.mu.Unlock()
+	for i, want := range []string{"55", "6765"} {
+		if pub.errs[i] != nil {
+			t.Fatalf("reply %d failed: %v", i+1, pub.errs[i])
+		}
+		if got := string(pub.replies[i].Body); got != want {
+			t.Fatalf("reply %d = %q, want %q", i+1, got, want)
+		}
+	}
+	waitFor(t, "acks", func() bool { return a.isAcked(1) && a.isAcked(2) })
+}
+
+func TestServerWorkersRunConcurrently(t *testing.T) {
+	const workers = 4
+	var mu sync.Mutex
+	inFlight, peak := 0, 0
+	release := make(chan struct{})
+	pub := &fakePublisher{block: func() {
+		mu.Lock()
+		inFlight++
+		peak = max(peak, inFlight)
+		if inFlight == workers {
+			close(release)
+		}
+		mu.Unlock()
+		<-release
+	}}
+	srv := &server{ch: pub, codecs: codec.Default(), maxN: defaultMaxN, timeout: time.Second}
+	msgs := make(chan amqp.Delivery, workers)
+	go srv.serve(msgs, workers)
+	defer close(msgs)
+
+	a := &acks{acked: make(map[uint64]bool)}
+	for i := 1; i <= workers; i++ {
+		msgs <- request(a, uint64(i), "5")
+	}
+	// every publish blocks until all workers are publishing at once
+	waitFor(t, "all replies", func() bool { return pub.count() == workers })
+	if peak != workers {
+		t.Fatalf("%d requests handled at once, want %d", peak, workers)
+	}
+}
+
+func TestServerRejectsInvalidN(t *testing.T) {
+	pub := &fakePublisher{}
+	srv := &server{ch: pub, codecs: codec.Default(), maxN: 100, timeout: time.Second}
+	a := &acks{acked: make(map[uint64]bool)}
+
+	for i, body := range []string{"-1", "101", "abc"} {
+		srv.handle(request(a, uint64(i+1), body))
+		reply := pub.replies[i]
+		if code := reply.Headers[errorCodeHeader]; code != "bad_request" {
+			t.Errorf("fib(%s) reply code = %v, want bad_request", body, code)
+		}
+		if !a.isAcked(uint64(i + 1)) {
+			t.Errorf("fib(%s) request not acked", body)
+		}
+	}
+}
+
+func TestServerStreamsFib(t *testing.T) {
+	pub := &fakePublisher{}
+	srv := &server{ch: pub, codecs: codec.Default(), maxN: defaultMaxN, timeout: time.Second}
+	a := &acks{acked: make(map[uint64]bool)}
+
+	d := request(a, 1, "6")
+	d.Headers = amqp.Table{streamHeader: true}
+	srv.handle(d)
+
+	want := []string{"0", "1", "1", "2", "3", "5", "8"}
+	if len(pub.replies) != len(want)+1 {
+		t.Fatalf("%d replies, want %d items and an end marker", len(pub.replies), len(want))
+	}
+	for i, reply := range pub.replies {
+		if seq := reply.Headers[seqHeader]; seq != int64(i) {
+			t.Fatalf("reply %d has %s %v", i, seqHeader, seq)
+		}
+		if reply.CorrelationId != d.CorrelationId {
+			t.Fatalf("reply %d correlation id %q", i, reply.CorrelationId)
+		}
+		if i < len(want) && string(reply.Body) != want[i] {
+			t.Fatalf("reply %d = %q, want %q", i, reply.Body, want[i])
+		}
+	}
+	if end := pub.replies[len(want)].Headers[streamEndHeader]; end != true {
+		t.Fatal("last reply is not an end-of-stream marker")
+	}
+	if !a.isAcked(1) {
+		t.Fatal("stream request not acked")
+	}
+}
+
+func TestServerRefusesStreamsOverClientWindow(t *testing.T) {
+	pub := &fakePublisher{}
+	srv := &server{ch: pub, codecs: codec.Default(), maxN: defaultMaxN, timeout: time.Second}
+	a := &acks{acked: make(map[uint64]bool)}
+
+	// fib(0..298) is 299 items and an end marker
+	d := request(a, 1, "298")
+	d.Headers = amqp.Table{streamHeader: true, streamWindowHeader: int64(256)}
+	srv.handle(d)
+	if pub.count() != 1 || pub.replies[0].Headers[errorCodeHeader] != "bad_request" {
+		t.Fatalf("%d replies, first %v; want a single bad_request", pub.count(), pub.replies[0].Headers)
+	}
+	if !a.isAcked(1) {
+		t.Fatal("refused stream request not acked")
+	}
+
+	// a window that fits gets the whole stream, well over 256 frames
+	pub = &fakePublisher{}
+	srv.ch = pub
+	d = request(a, 2, "298")
+	d.Headers = amqp.Table{streamHeader: true, streamWindowHeader: int32(300)}
+	srv.handle(d)
+	if pub.count() != 300 {
+		t.Fatalf("%d replies, want 299 items and an end marker", pub.count())
+	}
+	if end := pub.replies[299].Headers[streamEndHeader]; end != true {
+		t.Fatal("last reply is not an end-of-stream marker")
+	}
+}
+
+func TestRecoverCall(t *testing.T) {
+	_, err := recoverCall(func() ([]byte, error) { panic("boom") })
+	var perr *panicError
+	if !errors.As(err, &perr) || perr.value != "boom" || len(perr.stack) == 0 {
+		t.Fatalf("recoverCall of a panic = %v, want a *panicError with a stack", err)
+	}
+
+	body, err := recoverCall(func() ([]byte, error) { return []byte("ok"), nil })
+	if err != nil || string(body) != "ok" {
+		t.Fatalf("recoverCall = %q, %v", body, err)
+	}
+}
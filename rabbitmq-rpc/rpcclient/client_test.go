@@ -0,0 +1,704 @@
+package rpcclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// fakeChannel stands in for a broker channel. Published requests are
+// passed to serve, and any reply it returns is delivered to the consumer.
+type fakeChannel struct {
+	mu        sync.Mutex
+	declared  []string
+	consumed  string
+	autoAck   bool
+	published []amqp.Publishing
+	control   []amqp.Publishing // sent to an exchange rather than the queue
+	serve     func(amqp.Publishing) *amqp.Delivery
+
+	deliveries chan amqp.Delivery
+	closed     bool
+}
+
+func newFakeChannel(serve func(amqp.Publishing) *amqp.Delivery) *fakeChannel {
+	return &fakeChannel{serve: serve, deliveries: make(chan amqp.Delivery, 64)}
+}
+
+func (f *fakeChannel) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if name == "" {
+		name = "amq.gen-test"
+	}
+	f.declared = append(f.declared, name)
+	return amqp.Queue{Name: name}, nil
+}
+
+func (f *fakeChannel) Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.consumed, f.autoAck = queue, autoAck
+	return f.deliveries, nil
+}
+
+func (f *fakeChannel) ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	return nil
+}
+
+func (f *fakeChannel) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	f.mu.Lock()
+	if exchange != "" {
+		f.control = append(f.control, msg)
+		f.mu.Unlock()
+		return nil
+	}
+	f.published = append(f.published, msg)
+	f.mu.Unlock()
+	if f.serve == nil {
+		return nil
+	}
+	if reply := f.serve(msg); reply != nil {
+		go f.deliver(*reply)
+	}
+	return nil
+}
+
+// deliver hands d to the consumer unless the channel is closed
+func (f *fakeChannel) deliver(d amqp.Delivery) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.closed {
+		f.deliveries <- d
+	}
+}
+
+func (f *fakeChannel) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.closed {
+		f.closed = true
+		close(f.deliveries)
+	}
+	return nil
+}
+
+// echo replies with the request body under the request's correlation id
+func echo(msg amqp.Publishing) *amqp.Delivery {
+	return &amqp.Delivery{CorrelationId: msg.CorrelationId, ContentType: msg.ContentType, Body: msg.Body}
+}
+
+func (c *Client) pendingCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.pending)
+}
+
+func newTestClient(t *testing.T, ch *fakeChannel, direct bool) *Client {
+	t.Helper()
+	cfg := DefaultConfig()
+	cfg.DirectReplyTo = direct
+	c, err := New(ch, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestReplyModes(t *testing.T) {
+	tests := []struct {
+		name        string
+		direct      bool
+		wantReplyTo string
+		wantDeclare bool
+	}{
+		{"dedicated queue", false, "amq.gen-test", true},
+		{"direct reply-to", true, DirectReplyTo, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := newFakeChannel(echo)
+			c := newTestClient(t, ch, tt.direct)
+
+			if got := len(ch.declared) > 0; got != tt.wantDeclare {
+				t.Fatalf("declared reply queue = %v, want %v", got, tt.wantDeclare)
+			}
+			if ch.consumed != tt.wantReplyTo || !ch.autoAck {
+				t.Fatalf("consumed %q (autoAck %v), want %q in no-ack mode", ch.consumed, ch.autoAck, tt.wantReplyTo)
+			}
+
+			const calls = 200
+			var wg sync.WaitGroup
+			errs := make(chan error, calls)
+			for i := 0; i < calls; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					want := fmt.Sprint(i)
+					d, err := c.Call(context.Background(), amqp.Publishing{Body: []byte(want)})
+					if err != nil {
+						errs <- err
+					} else if string(d.Body) != want {
+						errs <- fmt.Errorf("call %d got reply %q", i, d.Body)
+					}
+				}(i)
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				t.Error(err)
+			}
+
+			for _, msg := range ch.published {
+				if msg.ReplyTo != tt.wantReplyTo {
+					t.Fatalf("request ReplyTo = %q, want %q", msg.ReplyTo, tt.wantReplyTo)
+				}
+			}
+			if n := c.pendingCount(); n != 0 {
+				t.Fatalf("%d calls still pending", n)
+			}
+		})
+	}
+}
+
+func TestCallTimeout(t *testing.T) {
+	c := newTestClient(t, newFakeChannel(nil), true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := c.Call(ctx, amqp.Publishing{Body: []byte("1")})
+	if !errors.Is(err, ErrTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want ErrTimeout", err)
+	}
+	if n := c.pendingCount(); n != 0 {
+		t.Fatalf("%d calls still pending after timeout", n)
+	}
+}
+
+func TestServerError(t *testing.T) {
+	ch := newFakeChannel(func(msg amqp.Publishing) *amqp.Delivery {
+		return &amqp.Delivery{
+			CorrelationId: msg.CorrelationId,
+			Headers:       amqp.Table{ErrorCodeHeader: "bad_request"},
+			Body:          []byte("not a number"),
+		}
+	})
+	c := newTestClient(t, ch, false)
+
+	_, err := c.Call(context.Background(), amqp.Publishing{Body: []byte("x")})
+	var se *ServerError
+	if !errors.As(err, &se) || se.Code != "bad_request" || se.Message != "not a number" {
+		t.Fatalf("err = %v, want bad_request ServerError", err)
+	}
+}
+
+func TestClosedAndLostConnection(t *testing.T) {
+	tests := []struct {
+		name string
+		stop func(c *Client, ch *fakeChannel)
+		want error
+	}{
+		{"Close", func(c *Client, _ *fakeChannel) { c.Close() }, ErrClosed},
+		{"connection lost", func(_ *Client, ch *fakeChannel) { ch.Close() }, ErrConnectionLost},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := newFakeChannel(nil)
+			c := newTestClient(t, ch, false)
+
+			errc := make(chan error)
+			go func() {
+				_, err := c.Call(context.Background(), amqp.Publishing{Body: []byte("1")})
+				errc <- err
+			}()
+			for c.pendingCount() != 1 {
+				time.Sleep(time.Millisecond)
+			}
+			tt.stop(c, ch)
+
+			select {
+			case err := <-errc:
+				if !errors.Is(err, tt.want) {
+					t.Fatalf("pending call err = %v, want %v", err, tt.want)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("pending call not failed")
+			}
+			if _, err := c.Call(context.Background(), amqp.Publishing{}); !errors.Is(err, tt.want) {
+				t.Fatalf("call after stop err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCorrelationIDs(t *testing.T) {
+	ch := newFakeChannel(echo)
+	c := newTestClient(t, ch, true)
+
+	for i := 0; i < 10; i++ {
+		if _, err := c.Call(context.Background(), amqp.Publishing{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seen := make(map[string]bool)
+	for _, msg := range ch.published {
+		id := msg.CorrelationId
+		if len(id) != 36 || id[14] != '7' || id[8] != '-' || id[23] != '-' {
+			t.Fatalf("correlation id %q is not a UUIDv7", id)
+		}
+		if msg.MessageId != id {
+			t.Fatalf("MessageId %q, want correlation id %q", msg.MessageId, id)
+		}
+		if seen[id] {
+			t.Fatalf("correlation id %q reused", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestDuplicateRepliesDropped(t *testing.T) {
+	var ch *fakeChannel
+	ch = newFakeChannel(func(msg amqp.Publishing) *amqp.Delivery {
+		reply := echo(msg)
+		// a stranger's reply and a duplicate arrive ahead of the real one
+		ch.deliver(amqp.Delivery{CorrelationId: "stranger", Body: []byte("wrong")})
+		ch.deliver(*reply)
+		return reply
+	})
+	c := newTestClient(t, ch, false)
+
+	for _, want := range []string{"first", "second"} {
+		d, err := c.Call(context.Background(), amqp.Publishing{Body: []byte(want)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(d.Body) != want {
+			t.Fatalf("got reply %q, want %q", d.Body, want)
+		}
+	}
+}
+
+func TestCallAsync(t *testing.T) {
+	c := newTestClient(t, newFakeChannel(echo), false)
+
+	f := c.CallAsync(context.Background(), amqp.Publishing{Body: []byte("async")})
+	d, err := f.Wait(context.Background())
+	if err != nil || string(d.Body) != "async" {
+		t.Fatalf("Wait = %q, %v", d.Body, err)
+	}
+	select {
+	case <-f.Done():
+	default:
+		t.Fatal("Done not closed after Wait returned")
+	}
+}
+
+func TestCallAll(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	var ch *fakeChannel
+	ch = newFakeChannel(func(msg amqp.Publishing) *amqp.Delivery {
+		mu.Lock()
+		inFlight++
+		maxInFlight = max(maxInFlight, inFlight)
+		mu.Unlock()
+		go func() {
+			time.Sleep(time.Millisecond)
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+			if string(msg.Body) == "13" {
+				ch.deliver(amqp.Delivery{CorrelationId: msg.CorrelationId,
+					Headers: amqp.Table{ErrorCodeHeader: "handler_error"}, Body: []byte("unlucky")})
+				return
+			}
+			ch.deliver(*echo(msg))
+		}()
+		return nil
+	})
+	c := newTestClient(t, ch, true)
+
+	msgs := make([]amqp.Publishing, 50)
+	for i := range msgs {
+		msgs[i].Body = []byte(fmt.Sprint(i))
+	}
+	replies, errs := c.CallAll(context.Background(), msgs, 4)
+
+	if maxInFlight > 4 {
+		t.Fatalf("%d calls in flight, want at most 4", maxInFlight)
+	}
+	for i := range msgs {
+		if i == 13 {
+			var se *ServerError
+			if !errors.As(errs[i], &se) {
+				t.Fatalf("errs[13] = %v, want ServerError", errs[i])
+			}
+			continue
+		}
+		if errs[i] != nil || string(replies[i].Body) != fmt.Sprint(i) {
+			t.Fatalf("result %d = %q, %v", i, replies[i].Body, errs[i])
+		}
+	}
+}
+
+func TestDoRetriesWithSameIdempotencyKey(t *testing.T) {
+	var mu sync.Mutex
+	requests := 0
+	ch := newFakeChannel(func(msg amqp.Publishing) *amqp.Delivery {
+		mu.Lock()
+		defer mu.Unlock()
+		if requests++; requests < 3 {
+			return nil // lost request, the attempt times out
+		}
+		return echo(msg)
+	})
+	cfg := DefaultConfig()
+	cfg.Retry = RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, Multiplier: 2, AttemptTimeout: 20 * time.Millisecond}
+	c, err := New(ch, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	d, err := c.Do(context.Background(), amqp.Publishing{Body: []byte("retry")})
+	if err != nil || string(d.Body) != "retry" {
+		t.Fatalf("Do = %q, %v", d.Body, err)
+	}
+	if len(ch.published) != 3 {
+		t.Fatalf("%d attempts, want 3", len(ch.published))
+	}
+	key := ch.published[0].Headers[IdempotencyKeyHeader]
+	ids := make(map[string]bool)
+	for _, msg := range ch.published {
+		if msg.Headers[IdempotencyKeyHeader] != key {
+			t.Fatalf("idempotency key changed between attempts: %v, %v", key, msg.Headers[IdempotencyKeyHeader])
+		}
+		ids[msg.CorrelationId] = true
+	}
+	if len(ids) != 3 {
+		t.Fatalf("attempts reused correlation ids")
+	}
+}
+
+func TestDoDoesNotRetryByDefault(t *testing.T) {
+	ch := newFakeChannel(func(msg amqp.Publishing) *amqp.Delivery {
+		return &amqp.Delivery{CorrelationId: msg.CorrelationId, Headers: amqp.Table{ErrorCodeHeader: "rate_limited"}}
+	})
+	c := newTestClient(t, ch, false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := c.Do(ctx, amqp.Publishing{}); err == nil {
+		t.Fatal("rate_limited reply succeeded")
+	}
+	if len(ch.published) != 1 {
+		t.Fatalf("%d attempts, want 1 unless the caller opts in to retries", len(ch.published))
+	}
+}
+
+func TestDoDoesNotRetryHandlerErrors(t *testing.T) {
+	ch := newFakeChannel(func(msg amqp.Publishing) *amqp.Delivery {
+		return &amqp.Delivery{CorrelationId: msg.CorrelationId, Headers: amqp.Table{ErrorCodeHeader: "handler_error"}}
+	})
+	c := newTestClient(t, ch, false)
+
+	var se *ServerError
+	if _, err := c.Do(context.Background(), amqp.Publishing{}); !errors.As(err, &se) {
+		t.Fatalf("err = %v, want ServerError", err)
+	}
+	if len(ch.published) != 1 {
+		t.Fatalf("%d attempts, want 1", len(ch.published))
+	}
+}
+
+func TestDoHedgesSlowRequests(t *testing.T) {
+	var ch *fakeChannel
+	var mu sync.Mutex
+	requests := 0
+	ch = newFakeChannel(func(msg amqp.Publishing) *amqp.Delivery {
+		mu.Lock()
+		defer mu.Unlock()
+		if requests++; requests == 1 {
+			// the original is slow and its reply arrives after the hedge won
+			go func() {
+				time.Sleep(100 * time.Millisecond)
+				ch.deliver(amqp.Delivery{CorrelationId: msg.CorrelationId, Body: []byte("late")})
+			}()
+			return nil
+		}
+		return echo(msg)
+	})
+	cfg := DefaultConfig()
+	cfg.Retry = RetryPolicy{}
+	cfg.Hedge = HedgePolicy{MaxHedges: 1, Percentile: 0.95, MinDelay: 10 * time.Millisecond}
+	c, err := New(ch, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	start := time.Now()
+	d, err := c.Do(context.Background(), amqp.Publishing{Body: []byte("hedged")})
+	if err != nil || string(d.Body) != "hedged" {
+		t.Fatalf("Do = %q, %v", d.Body, err)
+	}
+	if elapsed := time.Since(start); elapsed > 80*time.Millisecond {
+		t.Fatalf("hedged call took %v", elapsed)
+	}
+	if len(ch.published) != 2 ||
+		ch.published[0].Headers[IdempotencyKeyHeader] != ch.published[1].Headers[IdempotencyKeyHeader] {
+		t.Fatalf("want 2 requests sharing an idempotency key, got %d", len(ch.published))
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if n := c.pendingCount(); n != 0 {
+		t.Fatalf("%d calls still pending after the late reply", n)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	p := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 2, Jitter: 0.2}
+	for n, base := range map[int]time.Duration{1: 100 * time.Millisecond, 3: 400 * time.Millisecond, 10: time.Second} {
+		for i := 0; i < 100; i++ {
+			d := p.backoff(n)
+			if d < base*8/10 || d > base*12/10 {
+				t.Fatalf("backoff(%d) = %v, want %v ±20%%", n, d, base)
+			}
+		}
+	}
+}
+
+func TestLatencyPercentile(t *testing.T) {
+	w := newLatencyWindow(100)
+	if _, ok := w.percentile(0.95); ok {
+		t.Fatal("percentile reported with no samples")
+	}
+	for i := 1; i <= 100; i++ {
+		w.record(time.Duration(i) * time.Millisecond)
+	}
+	if d, ok := w.percentile(0.95); !ok || d != 95*time.Millisecond {
+		t.Fatalf("p95 = %v, %v", d, ok)
+	}
+}
+
+// streamOf replies to a streaming request with items numbered by seqs
+// followed by an end marker numbered end
+func streamOf(ch **fakeChannel, seqs []int64, end int64) func(amqp.Publishing) *amqp.Delivery {
+	return func(msg amqp.Publishing) *amqp.Delivery {
+		if msg.Headers[StreamHeader] != true {
+			panic("not a streaming request")
+		}
+		go func() {
+			for _, seq := range seqs {
+				(*ch).deliver(amqp.Delivery{CorrelationId: msg.CorrelationId,
+					Headers: amqp.Table{SeqHeader: seq}, Body: []byte(fmt.Sprint(seq))})
+			}
+			(*ch).deliver(amqp.Delivery{CorrelationId: msg.CorrelationId,
+				Headers: amqp.Table{SeqHeader: end, StreamEndHeader: true}})
+		}()
+		return nil
+	}
+}
+
+func collect(c *Client, ctx context.Context) ([]string, error) {
+	var got []string
+	for d, err := range c.Stream(ctx, amqp.Publishing{}) {
+		if err != nil {
+			return got, err
+		}
+		got = append(got, string(d.Body))
+	}
+	return got, nil
+}
+
+func TestStream(t *testing.T) {
+	var ch *fakeChannel
+	ch = newFakeChannel(streamOf(&ch, []int64{0, 1, 2, 2, 3, 1, 4}, 5))
+	c := newTestClient(t, ch, true)
+
+	got, err := collect(c, context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(got) != "[0 1 2 3 4]" {
+		t.Fatalf("stream = %v, want duplicates dropped", got)
+	}
+	if n := c.pendingCount(); n != 0 {
+		t.Fatalf("%d calls still pending after the stream ended", n)
+	}
+}
+
+func TestStreamGap(t *testing.T) {
+	var ch *fakeChannel
+	ch = newFakeChannel(streamOf(&ch, []int64{0, 1, 3}, 4))
+	c := newTestClient(t, ch, false)
+
+	got, err := collect(c, context.Background())
+	if !errors.Is(err, ErrStreamGap) || len(got) != 2 {
+		t.Fatalf("stream = %v, %v; want 2 items then ErrStreamGap", got, err)
+	}
+}
+
+func TestStreamEarlyBreak(t *testing.T) {
+	var ch *fakeChannel
+	ch = newFakeChannel(streamOf(&ch, []int64{0, 1, 2, 3, 4}, 5))
+	c := newTestClient(t, ch, false)
+
+	for d, err := range c.Stream(context.Background(), amqp.Publishing{}) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(d.Body) == "1" {
+			break
+		}
+	}
+	if n := c.pendingCount(); n != 0 {
+		t.Fatalf("%d calls still pending after break", n)
+	}
+}
+
+func TestStreamSlowConsumer(t *testing.T) {
+	var ch *fakeChannel
+	ch = newFakeChannel(streamOf(&ch, []int64{0, 1, 2, 3, 4, 5, 6, 7}, 8))
+	cfg := DefaultConfig()
+	cfg.StreamBuffer = 2
+	c, err := New(ch, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var last error
+	for _, err := range c.Stream(context.Background(), amqp.Publishing{}) {
+		time.Sleep(20 * time.Millisecond) // fall behind the server
+		last = err
+	}
+	if !errors.Is(last, ErrSlowConsumer) {
+		t.Fatalf("err = %v, want ErrSlowConsumer", last)
+	}
+}
+
+func TestStreamSlowConsumerWithinWindow(t *testing.T) {
+	const items = 500
+	var ch *fakeChannel
+	window := make(chan any, 1)
+	sent := make(chan struct{})
+	ch = newFakeChannel(func(msg amqp.Publishing) *amqp.Delivery {
+		window <- msg.Headers[StreamWindowHeader]
+		go func() {
+			defer close(sent)
+			for seq := int64(0); seq <= items; seq++ {
+				ch.deliver(amqp.Delivery{CorrelationId: msg.CorrelationId,
+					Headers: amqp.Table{SeqHeader: seq, StreamEndHeader: seq == items}})
+			}
+		}()
+		return nil
+	})
+	cfg := DefaultConfig()
+	cfg.StreamBuffer = items + 1
+	c, err := New(ch, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	got := 0
+	for _, err := range c.Stream(context.Background(), amqp.Publishing{}) {
+		if err != nil {
+			t.Fatalf("after %d items: %v", got, err)
+		}
+		if got == 0 {
+			// stall until the whole stream is waiting in the buffer
+			<-sent
+			for len(ch.deliveries) > 0 {
+				time.Sleep(time.Millisecond)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		got++
+	}
+	if got != items {
+		t.Fatalf("got %d items, want %d", got, items)
+	}
+	if w := <-window; w != int64(items+1) {
+		t.Fatalf("%s = %v, want %d", StreamWindowHeader, w, items+1)
+	}
+}
+
+func TestStreamCancel(t *testing.T) {
+	c := newTestClient(t, newFakeChannel(nil), false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	if _, err := collect(c, ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if n := c.pendingCount(); n != 0 {
+		t.Fatalf("%d calls still pending after cancel", n)
+	}
+}
+
+// cancels returns the correlation ids cancelled through the control exchange
+func (f *fakeChannel) cancels() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var ids []string
+	for _, msg := range f.control {
+		if msg.Type == CancelType {
+			ids = append(ids, msg.CorrelationId)
+		}
+	}
+	return ids
+}
+
+func waitForCancel(t *testing.T, ch *fakeChannel, id string) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		for _, got := range ch.cancels() {
+			if got == id {
+				return
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("no cancel sent for %q (sent %v)", id, ch.cancels())
+}
+
+func TestAbandonedCallsAreCancelled(t *testing.T) {
+	ch := newFakeChannel(nil)
+	c := newTestClient(t, ch, false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.Call(ctx, amqp.Publishing{}); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("err = %v, want ErrTimeout", err)
+	}
+	waitForCancel(t, ch, ch.published[0].CorrelationId)
+}
+
+func TestAnsweredCallsAreNotCancelled(t *testing.T) {
+	var ch *fakeChannel
+	ch = newFakeChannel(streamOf(&ch, []int64{0, 1}, 2))
+	c := newTestClient(t, ch, false)
+
+	if _, err := collect(c, context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if ids := ch.cancels(); len(ids) != 0 {
+		t.Fatalf("finished stream cancelled: %v", ids)
+	}
+
+	// breaking out early abandons the stream
+	for range c.Stream(context.Background(), amqp.Publishing{}) {
+		break
+	}
+	waitForCancel(t, ch, ch.published[1].CorrelationId)
+}
@@ -0,0 +1,63 @@
+package rpcclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// RetryAfterHeader carries how long to back off after a rate_limited or
+// circuit_open reply, in milliseconds
+const RetryAfterHeader = "x-retry-after-ms"
+
+var (
+	// ErrClosed is returned by calls made on, or pending when, the client closes
+	ErrClosed = errors.New("rpcclient: client closed")
+	// ErrConnectionLost is returned when the reply consumer stops underneath
+	// pending calls, usually because the channel or connection went away
+	ErrConnectionLost = errors.New("rpcclient: connection lost")
+	// ErrTimeout is returned when the call's context deadline passes before
+	// the reply arrives. It also matches context.DeadlineExceeded.
+	ErrTimeout = errors.New("rpcclient: call timed out")
+	// ErrStreamGap is returned when a stream skips a sequence number
+	ErrStreamGap = errors.New("rpcclient: stream is missing replies")
+	// ErrSlowConsumer is returned when a stream's reader falls more than
+	// Config.StreamBuffer replies behind, which only happens when the
+	// server ignores StreamWindowHeader
+	ErrSlowConsumer = errors.New("rpcclient: stream reader fell behind")
+)
+
+// ServerError is an error reply from the server
+type ServerError struct {
+	Code    string // x-error-code header, e.g. "handler_error"
+	Message string
+	// RetryAfter is the server's requested backoff, if it sent one
+	RetryAfter time.Duration
+}
+
+// serverError builds the error for an error reply
+func serverError(d amqp.Delivery) *ServerError {
+	e := &ServerError{Code: fmt.Sprint(d.Headers[ErrorCodeHeader]), Message: string(d.Body)}
+	switch ms := d.Headers[RetryAfterHeader].(type) {
+	case int64:
+		e.RetryAfter = time.Duration(ms) * time.Millisecond
+	case int32:
+		e.RetryAfter = time.Duration(ms) * time.Millisecond
+	}
+	return e
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("server error (%s): %s", e.Code, e.Message)
+}
+
+// contextError maps the reason ctx ended to the client's errors
+func contextError(ctx context.Context) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %w", ErrTimeout, ctx.Err())
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,73 @@
+package rpcclient
+
+import (
+	"context"
+	"sync"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Future is the pending result of CallAsync
+type Future struct {
+	done  chan struct{}
+	reply amqp.Delivery
+	err   error
+}
+
+// Done is closed once the result is available
+func (f *Future) Done() <-chan struct{} { return f.done }
+
+// Wait blocks until the call completes or ctx ends. Giving up on Wait does
+// not cancel the call; that is governed by the ctx passed to CallAsync.
+func (f *Future) Wait(ctx context.Context) (amqp.Delivery, error) {
+	select {
+	case <-f.done:
+		return f.reply, f.err
+	case <-ctx.Done():
+		return amqp.Delivery{}, contextError(ctx)
+	}
+}
+
+// CallAsync starts Call in the background and returns its Future
+func (c *Client) CallAsync(ctx context.Context, msg amqp.Publishing) *Future {
+	f := &Future{done: make(chan struct{})}
+	go func() {
+		f.reply, f.err = c.Call(ctx, msg)
+		close(f.done)
+	}()
+	return f
+}
+
+// CallAll sends every request with at most concurrency calls in flight
+// and returns the replies in request order. errs[i] holds the error of
+// msgs[i]; a failed call does not stop the others.
+func (c *Client) CallAll(ctx context.Context, msgs []amqp.Publishing, concurrency int) ([]amqp.Delivery, []error) {
+	if concurrency <= 0 || concurrency > len(msgs) {
+		concurrency = len(msgs)
+	}
+	replies := make([]amqp.Delivery, len(msgs))
+	errs := make([]error, len(msgs))
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+	for i, msg := range msgs {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			// requests never sent fail with the context's error
+			for j := i; j < len(msgs); j++ {
+				errs[j] = contextError(ctx)
+			}
+			wg.Wait()
+			return replies, errs
+		}
+		wg.Add(1)
+		go func(i int, msg amqp.Publishing) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			replies[i], errs[i] = c.Call(ctx, msg)
+		}(i, msg)
+	}
+	wg.Wait()
+	return replies, errs
+}
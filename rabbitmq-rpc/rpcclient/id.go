@@ -0,0 +1,59 @@
+package rpcclient
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"time"
+)
+
+// newID returns a UUIDv7: a 48-bit millisecond timestamp followed by
+// crypto-random bits, so IDs sort by creation time and never collide
+// between concurrent clients in practice
+func newID() string {
+	var u [16]byte
+	if _, err := rand.Read(u[:]); err != nil {
+		panic("rpcclient: crypto/rand failed: " + err.Error())
+	}
+	var ms [8]byte
+	binary.BigEndian.PutUint64(ms[:], uint64(time.Now().UnixMilli()))
+	copy(u[:6], ms[2:])
+	u[6] = u[6]&0x0f | 0x70 // version 7
+	u[8] = u[8]&0x3f | 0x80 // RFC 4122 variant
+
+	var buf [36]byte
+	hex.Encode(buf[0:8], u[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], u[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], u[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], u[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], u[10:])
+	return string(buf[:])
+}
+
+// recentIDs remembers the last few completed correlation IDs so a second
+// reply to the same call can be told apart from a reply to a stranger
+type recentIDs struct {
+	ids  []string
+	next int
+	set  map[string]struct{}
+}
+
+func newRecentIDs(size int) *recentIDs {
+	return &recentIDs{ids: make([]string, size), set: make(map[string]struct{}, size)}
+}
+
+func (r *recentIDs) add(id string) {
+	delete(r.set, r.ids[r.next])
+	r.ids[r.next] = id
+	r.set[id] = struct{}{}
+	r.next = (r.next + 1) % len(r.ids)
+}
+
+func (r *recentIDs) has(id string) bool {
+	_, ok := r.set[id]
+	return ok
+}
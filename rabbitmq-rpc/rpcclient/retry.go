@@ -0,0 +1,237 @@
+package rpcclient
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// IdempotencyKeyHeader carries a key shared by every attempt and hedge of
+// one logical request, so the server can recognise duplicates
+const IdempotencyKeyHeader = "x-idempotency-key"
+
+// RetryPolicy controls how Do retries failed attempts. Timeouts and
+// rate_limited or circuit_open replies are retried; other errors are not.
+type RetryPolicy struct {
+	MaxAttempts    int // including the first; 0 or 1 disables retries
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	// Jitter randomises each backoff by up to this fraction either way
+	Jitter float64
+	// AttemptTimeout bounds each attempt so a lost request can be retried
+	// before ctx expires. 0 leaves attempts bounded by ctx only.
+	AttemptTimeout time.Duration
+}
+
+// DefaultRetryPolicy returns three attempts with exponential backoff, for
+// callers that opt in to retries. Attempts are bounded by ctx only; set
+// AttemptTimeout above the server's processing timeout to also retry
+// lost requests.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     2 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+	}
+}
+
+// backoff returns the wait before attempt n+1 after attempt n failed
+func (p RetryPolicy) backoff(n int) time.Duration {
+	mult := p.Multiplier
+	if mult < 1 {
+		mult = 1
+	}
+	d := float64(p.InitialBackoff) * math.Pow(mult, float64(n-1))
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		d *= 1 + p.Jitter*(2*rand.Float64()-1)
+	}
+	return time.Duration(d)
+}
+
+// HedgePolicy controls hedged requests: when an attempt has not been
+// answered within the Percentile latency of recent calls, a duplicate is
+// sent and the first reply wins. MaxHedges 0 disables hedging.
+type HedgePolicy struct {
+	MaxHedges  int     // extra requests per attempt
+	Percentile float64 // e.g. 0.95
+	// MinDelay is the lowest hedge delay, also used until enough calls
+	// have been seen to estimate the percentile
+	MinDelay time.Duration
+}
+
+// retryable reports whether another attempt may succeed where err failed
+func retryable(err error) bool {
+	var se *ServerError
+	if errors.As(err, &se) {
+		return se.Code == "rate_limited" || se.Code == "circuit_open"
+	}
+	return errors.Is(err, ErrTimeout)
+}
+
+// Do sends msg under the client's retry and hedge policies. Every attempt
+// and hedge carries the same idempotency key; an existing
+// x-idempotency-key header is kept. Servers are not required to
+// deduplicate on it, so a call that timed out may have run: only enable
+// retries or hedging for calls that are safe to repeat.
+func (c *Client) Do(ctx context.Context, msg amqp.Publishing) (amqp.Delivery, error) {
+	msg.Headers = cloneTable(msg.Headers)
+	if _, ok := msg.Headers[IdempotencyKeyHeader]; !ok {
+		msg.Headers[IdempotencyKeyHeader] = newID()
+	}
+
+	p := c.cfg.Retry
+	for attempt := 1; ; attempt++ {
+		d, err := c.attempt(ctx, msg)
+		if err == nil || attempt >= p.MaxAttempts || !retryable(err) || ctx.Err() != nil {
+			if err != nil && ctx.Err() != nil {
+				return d, contextError(ctx)
+			}
+			return d, err
+		}
+
+		wait := p.backoff(attempt)
+		var se *ServerError
+		if errors.As(err, &se) && se.RetryAfter > wait {
+			wait = se.RetryAfter
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return amqp.Delivery{}, contextError(ctx)
+		}
+	}
+}
+
+// attempt makes one try at msg, hedging it if the policy allows
+func (c *Client) attempt(ctx context.Context, msg amqp.Publishing) (amqp.Delivery, error) {
+	if c.cfg.Retry.AttemptTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.cfg.Retry.AttemptTimeout)
+		defer cancel()
+	}
+	h := c.cfg.Hedge
+	if h.MaxHedges <= 0 {
+		return c.Call(ctx, msg)
+	}
+
+	// Cancelling on return abandons the losing calls, so their late
+	// replies are dropped by dispatch
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		d   amqp.Delivery
+		err error
+	}
+	results := make(chan result, 1+h.MaxHedges)
+	launch := func() {
+		go func() {
+			d, err := c.Call(ctx, msg)
+			results <- result{d, err}
+		}()
+	}
+
+	delay := c.hedgeDelay()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	launch()
+	launched, failed := 1, 0
+	var firstErr error
+	for {
+		select {
+		case <-timer.C:
+			if launched <= h.MaxHedges {
+				launch()
+				launched++
+				timer.Reset(delay)
+			}
+		case r := <-results:
+			if r.err == nil {
+				return r.d, nil
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			if failed++; failed == launched {
+				return amqp.Delivery{}, firstErr
+			}
+		}
+	}
+}
+
+// hedgeDelay is how long an attempt waits before sending a hedge
+func (c *Client) hedgeDelay() time.Duration {
+	h := c.cfg.Hedge
+	d, ok := c.latency.percentile(h.Percentile)
+	if !ok || d < h.MinDelay {
+		return h.MinDelay
+	}
+	return d
+}
+
+// latencyWindow keeps the latencies of the most recent successful calls
+type latencyWindow struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+	full    bool
+}
+
+// minLatencySamples is how many calls must complete before percentiles are trusted
+const minLatencySamples = 20
+
+func newLatencyWindow(size int) *latencyWindow {
+	return &latencyWindow{samples: make([]time.Duration, size)}
+}
+
+func (w *latencyWindow) record(d time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.samples[w.next] = d
+	w.next = (w.next + 1) % len(w.samples)
+	if w.next == 0 {
+		w.full = true
+	}
+}
+
+// percentile returns the p-th latency (0 < p <= 1) of the window
+func (w *latencyWindow) percentile(p float64) (time.Duration, bool) {
+	w.mu.Lock()
+	n := w.next
+	if w.full {
+		n = len(w.samples)
+	}
+	sorted := slices.Clone(w.samples[:n])
+	w.mu.Unlock()
+
+	if n < minLatencySamples || p <= 0 {
+		return 0, false
+	}
+	slices.Sort(sorted)
+	i := int(math.Ceil(p*float64(n))) - 1
+	return sorted[min(max(i, 0), n-1)], true
+}
+
+// cloneTable copies headers so concurrent publishes don't share one map
+func cloneTable(t amqp.Table) amqp.Table {
+	out := make(amqp.Table, len(t)+2)
+	for k, v := range t {
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,109 @@
+package rpcclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"log"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Headers of the streaming protocol. A streaming request sets StreamHeader
+// and StreamWindowHeader, the most replies the client can hold; servers
+// refuse longer streams with a bad_request error rather than overrun it.
+// The server answers with replies numbered from 0 in SeqHeader under the
+// request's correlation ID, and ends with an empty reply that also sets
+// StreamEndHeader and whose SeqHeader is the number of items.
+const (
+	StreamHeader       = "x-stream"
+	StreamWindowHeader = "x-stream-window"
+	SeqHeader          = "x-seq"
+	StreamEndHeader    = "x-stream-end"
+)
+
+// Stream sends msg as a streaming request and yields its replies in
+// order. Duplicates are dropped; a gap in the sequence ends the stream
+// with ErrStreamGap, and an error reply with *ServerError. A stream longer
+// than Config.StreamBuffer, end marker included, is refused by the server.
+// Breaking out of the loop or cancelling ctx abandons the stream, and
+// later replies are dropped. Abandoned streams are cancelled on the server
+// like Call.
+func (c *Client) Stream(ctx context.Context, msg amqp.Publishing) iter.Seq2[amqp.Delivery, error] {
+	return func(yield func(amqp.Delivery, error) bool) {
+		msg.Headers = cloneTable(msg.Headers)
+		msg.Headers[StreamHeader] = true
+		msg.Headers[StreamWindowHeader] = int64(c.cfg.StreamBuffer)
+
+		pc := &call{done: make(chan struct{}), stream: make(chan amqp.Delivery, c.cfg.StreamBuffer)}
+		id, err := c.send(ctx, msg, pc)
+		if err != nil {
+			yield(amqp.Delivery{}, err)
+			return
+		}
+		defer c.forget(id)
+		// Tell the server to stop unless it already finished the stream
+		finished := false
+		defer func() {
+			if !finished {
+				c.cancel(id)
+			}
+		}()
+
+		var next int64
+		for {
+			var d amqp.Delivery
+			select {
+			case d = <-pc.stream:
+			case <-pc.done:
+				yield(amqp.Delivery{}, pc.err)
+				return
+			case <-ctx.Done():
+				yield(amqp.Delivery{}, contextError(ctx))
+				return
+			}
+
+			if err := c.open(&d); err != nil {
+				var se *ServerError
+				finished = errors.As(err, &se)
+				yield(d, err)
+				return
+			}
+			seq, ok := sequence(d)
+			if !ok {
+				yield(d, fmt.Errorf("rpcclient: stream reply without %s header", SeqHeader))
+				return
+			}
+			if seq < next {
+				log.Printf("rpcclient: dropping duplicate stream reply %d for correlation id %q", seq, id)
+				continue
+			}
+			if seq > next {
+				yield(d, fmt.Errorf("%w: expected %d, got %d", ErrStreamGap, next, seq))
+				return
+			}
+			if end, _ := d.Headers[StreamEndHeader].(bool); end {
+				finished = true
+				return
+			}
+			next++
+			if !yield(d, nil) {
+				return
+			}
+		}
+	}
+}
+
+// sequence reads the SeqHeader of a stream reply
+func sequence(d amqp.Delivery) (int64, bool) {
+	switch v := d.Headers[SeqHeader].(type) {
+	case int64:
+		return v, true
+	case int32:
+		return int64(v), true
+	case int:
+		return int64(v), true
+	}
+	return 0, false
+}
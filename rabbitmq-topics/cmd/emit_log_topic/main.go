@@ -7,6 +7,8 @@ import (
 	"strings"
 	"time"
 
+	"github.com/Ashraful52038/RabbitMq/pkg/compress"
+	"github.com/Ashraful52038/RabbitMq/pkg/tracing"
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
@@ -45,6 +47,9 @@ func main() {
 	failOnError(err, "Failed to open a channel")
 	defer ch.Close()
 
+	tracer := tracing.FromEnv("emit_log_topic")
+	defer tracer.Close()
+
 	// Topic Exchange declare
 	err = ch.ExchangeDeclare(
 		"logs_topic", // name
@@ -64,15 +69,24 @@ func main() {
 	routingKey := severityFrom(os.Args)
 	body := bodyFrom(os.Args)
 
+	msg := amqp.Publishing{
+		ContentType: "text/plain",
+		Body:        []byte(body),
+	}
+	opts, err := compress.OptionsFromEnv()
+	failOnError(err, "Invalid compression")
+	err = compress.Publishing(&msg, opts)
+	failOnError(err, "Failed to compress message")
+
+	_, span := tracer.StartPublish(ctx, &msg, "logs_topic", routingKey)
 	err = ch.PublishWithContext(ctx,
 		"logs_topic", // exchange
 		routingKey,   // routing key (topic pattern)
 		false,        // mandatory
 		false,        // immediate
-		amqp.Publishing{
-			ContentType: "text/plain",
-			Body:        []byte(body),
-		})
+		msg)
+	span.RecordError(err)
+	span.End()
 	failOnError(err, "Failed to publish a message")
 
 	log.Printf(" [x] Sent %s: %s", routingKey, body)
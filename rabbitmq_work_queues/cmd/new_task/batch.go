@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"sync"
+
+	"github.com/Ashraful52038/RabbitMq/pkg/tracing"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"rabbitmq_work_queues/task"
+)
+
+// batch formats, one task per line
+const (
+	formatText = "text"
+	formatJSON = "json"
+)
+
+// maxLineSize bounds one task in a batch
+const maxLineSize = 1 << 20
+
+// batchReport counts what happened to the tasks of one batch
+type batchReport struct {
+	Published int
+	Confirmed int // acked by the broker and routed to a queue
+	Nacked    int
+	Returned  int // unroutable, sent back by the broker
+}
+
+// Failed is how many published tasks did not reach a queue
+func (r batchReport) Failed() int {
+	return r.Published - r.Confirmed
+}
+
+// confirmation is the broker's verdict on one published task
+type confirmation interface {
+	// Wait blocks until the broker acks (true) or nacks (false) the task
+	Wait() bool
+}
+
+// taskChannel is the part of an AMQP channel that publishes tasks
+type taskChannel interface {
+	Confirm(noWait bool) error
+	NotifyReturn(c chan amqp.Return) chan amqp.Return
+	Close() error
+	// publish sends msg as mandatory, so an unroutable task is returned
+	publish(ctx context.Context, exchange, key string, msg amqp.Publishing) (confirmation, error)
+}
+
+// confirmChannel publishes tasks on an *amqp.Channel
+type confirmChannel struct {
+	*amqp.Channel
+}
+
+func (ch confirmChannel) publish(ctx context.Context, exchange, key string, msg amqp.Publishing) (confirmation, error) {
+	return ch.PublishWithDeferredConfirmWithContext(ctx,
+		exchange, // exchange
+		key,      // routing key
+		true,     // mandatory
+		false,    // immediate
+		msg)
+}
+
+// batchTask is a published task waiting for its confirm
+type batchTask struct {
+	id      string
+	confirm confirmation
+}
+
+// confirmedPublisher publishes tasks under publisher confirms, keeping at
+// most window of them unconfirmed, and counts what the broker did with them
+type confirmedPublisher struct {
+	ch       taskChannel
+	tracer   *tracing.Tracer
+	exchange string
+	key      string
+	window   int
+
+	report  batchReport
+	acked   int
+	pending []batchTask
+	wg      sync.WaitGroup
+}
+
+// newConfirmedPublisher puts ch in confirm mode and starts counting returns
+func newConfirmedPublisher(ch taskChannel, tracer *tracing.Tracer, exchange, key string, window int) (*confirmedPublisher, error) {
+	if err := ch.Confirm(false); err != nil {
+		return nil, fmt.Errorf("failed to enable publisher confirms: %w", err)
+	}
+	p := &confirmedPublisher{ch: ch, tracer: tracer, exchange: exchange, key: key, window: window}
+
+	// a returned task is still acked, so returns are subtracted at the end
+	returns := ch.NotifyReturn(make(chan amqp.Return, window))
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		for ret := range returns {
+			p.report.Returned++
+			log.Printf(" [!] Task %s returned: %s", ret.MessageId, ret.ReplyText)
+		}
+	}()
+	return p, nil
+}
+
+// Publish sends e with id as its MessageId, first waiting for the oldest
+// confirm if window tasks are unconfirmed
+func (p *confirmedPublisher) Publish(e task.Envelope, id string) error {
+	if len(p.pending) == p.window {
+		p.wait(p.pending[0])
+		p.pending = p.pending[1:]
+	}
+	confirm, err := publishTask(p.ch, p.tracer, p.exchange, p.key, e, id)
+	if err != nil {
+		return err
+	}
+	p.report.Published++
+	p.pending = append(p.pending, batchTask{id: id, confirm: confirm})
+	return nil
+}
+
+func (p *confirmedPublisher) wait(t batchTask) {
+	if t.confirm.Wait() {
+		p.acked++
+		return
+	}
+	p.report.Nacked++
+	log.Printf(" [!] Task %s nacked", t.id)
+}
+
+// Close waits for every confirm and closes the channel, which closes the
+// returns once every return has been counted
+func (p *confirmedPublisher) Close() batchReport {
+	for _, t := range p.pending {
+		p.wait(t)
+	}
+	p.pending = nil
+	p.ch.Close()
+	p.wg.Wait()
+	p.report.Confirmed = p.acked - p.report.Returned
+	return p.report
+}
+
+// publishBatch publishes every line of r to exchange with key under
+// publisher confirms, keeping at most window tasks unconfirmed at once. The
+// channel is closed when it returns so every return has been counted.
+func publishBatch(ch taskChannel, tracer *tracing.Tracer, exchange, key string, r io.Reader, format, typ string, window int) (batchReport, error) {
+	p, err := newConfirmedPublisher(ch, tracer, exchange, key, window)
+	if err != nil {
+		return batchReport{}, err
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Bytes()
+		if len(text) == 0 {
+			continue
+		}
+		e, perr := taskFromLine(text, format, typ)
+		if perr == nil {
+			perr = p.Publish(e, strconv.Itoa(line))
+		}
+		if perr != nil {
+			err = fmt.Errorf("line %d: %w", line, perr)
+			break
+		}
+	}
+	if err == nil {
+		err = scanner.Err()
+	}
+	return p.Close(), err
+}
+
+// publishOne publishes a single task like a batch of one, so a nacked or
+// unroutable task is reported rather than lost
+func publishOne(ch taskChannel, tracer *tracing.Tracer, exchange, key string, e task.Envelope) (batchReport, error) {
+	p, err := newConfirmedPublisher(ch, tracer, exchange, key, 1)
+	if err != nil {
+		return batchReport{}, err
+	}
+	err = p.Publish(e, "1")
+	return p.Close(), err
+}
+
+// taskFromLine builds the task for one batch line. A text line is the
+// payload string of a typ task; a JSON line is an object with "type" and
+// "payload", the type defaulting to typ.
+func taskFromLine(line []byte, format, typ string) (task.Envelope, error) {
+	if format == formatText {
+		payload, err := json.Marshal(string(line))
+		if err != nil {
+			return task.Envelope{}, err
+		}
+		return task.New(typ, payload)
+	}
+
+	var in struct {
+		Type    string          `json:"type"`
+		Payload json.RawMessage `json:"payload"`
+	}
+	if err := json.Unmarshal(line, &in); err != nil {
+		return task.Envelope{}, fmt.Errorf("invalid JSON task: %w", err)
+	}
+	if in.Type == "" {
+		in.Type = typ
+	}
+	return task.New(in.Type, in.Payload)
+}
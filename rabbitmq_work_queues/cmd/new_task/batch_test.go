@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Ashraful52038/RabbitMq/pkg/tracing"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"rabbitmq_work_queues/task"
+)
+
+// fakeChannel records published tasks, nacks or returns them by MessageId
+// and tracks how many are unconfirmed
+type fakeChannel struct {
+	nack, unroutable map[string]bool
+
+	mu                 sync.Mutex
+	msgs               []amqp.Publishing
+	unconfirmed, peak  int
+	confirming, closed bool
+	returns            chan amqp.Return
+}
+
+func (f *fakeChannel) Confirm(noWait bool) error {
+	f.confirming = true
+	return nil
+}
+
+func (f *fakeChannel) NotifyReturn(c chan amqp.Return) chan amqp.Return {
+	f.returns = c
+	return c
+}
+
+func (f *fakeChannel) Close() error {
+	f.closed = true
+	close(f.returns)
+	return nil
+}
+
+func (f *fakeChannel) publish(ctx context.Context, exchange, key string, msg amqp.Publishing) (confirmation, error) {
+	if !f.confirming {
+		return nil, errors.New("channel not in confirm mode")
+	}
+	f.mu.Lock()
+	f.msgs = append(f.msgs, msg)
+	f.unconfirmed++
+	f.peak = max(f.peak, f.unconfirmed)
+	f.mu.Unlock()
+
+	if f.unroutable[msg.MessageId] {
+		f.returns <- amqp.Return{MessageId: msg.MessageId, ReplyText: "NO_ROUTE"}
+	}
+	return &fakeConfirm{f: f, ack: !f.nack[msg.MessageId]}, nil
+}
+
+type fakeConfirm struct {
+	f   *fakeChannel
+	ack bool
+}
+
+func (c *fakeConfirm) Wait() bool {
+	c.f.mu.Lock()
+	c.f.unconfirmed--
+	c.f.mu.Unlock()
+	return c.ack
+}
+
+func testTracer() *tracing.Tracer {
+	return tracing.NewTracer("new_task", nil)
+}
+
+func TestPublishBatch(t *testing.T) {
+	ch := &fakeChannel{}
+	r := strings.NewReader("first\n\nsecond\nthird\n")
+	report, err := publishBatch(ch, testTracer(), "", "task_queue", r, formatText, "echo", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report != (batchReport{Published: 3, Confirmed: 3}) || report.Failed() != 0 {
+		t.Fatalf("report = %+v", report)
+	}
+	if !ch.closed {
+		t.Fatal("channel left open, returns may not all be counted")
+	}
+
+	// blank lines are skipped but still counted as lines
+	for i, want := range []struct{ id, payload string }{{"1", `"first"`}, {"3", `"second"`}, {"4", `"third"`}} {
+		msg := ch.msgs[i]
+		e, err := task.Decode(amqp.Delivery{Body: msg.Body, ContentType: msg.ContentType})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.MessageId != want.id || e.Type != "echo" || string(e.Payload) != want.payload {
+			t.Errorf("task %d = %s %s %s, want %s echo %s", i, msg.MessageId, e.Type, e.Payload, want.id, want.payload)
+		}
+		if e.TraceID == "" {
+			t.Errorf("task %d has no trace ID", i)
+		}
+	}
+}
+
+func TestPublishBatchWindow(t *testing.T) {
+	ch := &fakeChannel{}
+	r := strings.NewReader(strings.Repeat("task\n", 20))
+	report, err := publishBatch(ch, testTracer(), "", "task_queue", r, formatText, "echo", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Confirmed != 20 {
+		t.Fatalf("report = %+v", report)
+	}
+	if ch.peak != 3 {
+		t.Fatalf("%d tasks unconfirmed at once, window is 3", ch.peak)
+	}
+	if ch.unconfirmed != 0 {
+		t.Fatalf("%d confirms never waited for", ch.unconfirmed)
+	}
+}
+
+func TestPublishBatchNacksAndReturns(t *testing.T) {
+	ch := &fakeChannel{
+		nack:       map[string]bool{"2": true},
+		unroutable: map[string]bool{"3": true, "4": true},
+	}
+	r := strings.NewReader("a\nb\nc\nd\ne\n")
+	report, err := publishBatch(ch, testTracer(), "", "task_queue", r, formatText, "echo", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := batchReport{Published: 5, Confirmed: 2, Nacked: 1, Returned: 2}
+	if report != want || report.Failed() != 3 {
+		t.Fatalf("report = %+v, want %+v", report, want)
+	}
+}
+
+func TestPublishBatchStops(t *testing.T) {
+	tests := []struct {
+		name, input, want string
+		published         int
+	}{
+		{"bad JSON", `{"type": "echo", "payload": 1}` + "\n{not json\n" + `{"payload": 3}` + "\n", "line 2: invalid JSON task", 1},
+		{"line too long", `{"payload": 1}` + "\n" + strings.Repeat("x", maxLineSize+1) + "\n", "token too long", 1},
+	}
+	for _, tt := range tests {
+		ch := &fakeChannel{}
+		report, err := publishBatch(ch, testTracer(), "", "task_queue", strings.NewReader(tt.input), formatJSON, "echo", 10)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error = %v, want %q", tt.name, err, tt.want)
+		}
+		// what was published before the bad line is still confirmed
+		if report.Published != tt.published || report.Confirmed != tt.published || !ch.closed {
+			t.Errorf("%s: report = %+v, channel closed %v", tt.name, report, ch.closed)
+		}
+	}
+}
+
+func TestPublishBatchFromStdin(t *testing.T) {
+	stdin := os.Stdin
+	defer func() { os.Stdin = stdin }()
+	f, err := os.CreateTemp(t.TempDir(), "tasks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(`{"type": "resize", "payload": {"w": 10}}` + "\n" + `{"payload": "x"}` + "\n"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	os.Stdin = f
+
+	tasks, err := openTasks("-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tasks.Close()
+	ch := &fakeChannel{}
+	report, err := publishBatch(ch, testTracer(), "", "task_queue", tasks, formatJSON, "echo", 10)
+	if err != nil || report.Confirmed != 2 {
+		t.Fatalf("report = %+v, err %v", report, err)
+	}
+
+	var types []string
+	for _, msg := range ch.msgs {
+		e, err := task.Decode(amqp.Delivery{Body: msg.Body, ContentType: msg.ContentType})
+		if err != nil {
+			t.Fatal(err)
+		}
+		types = append(types, e.Type)
+	}
+	// a JSON line without a type gets the default
+	if strings.Join(types, ",") != "resize,echo" {
+		t.Fatalf("types = %v, want resize,echo", types)
+	}
+}
+
+func TestTaskFromLine(t *testing.T) {
+	e, err := taskFromLine([]byte(`say "hi"`), formatText, "echo")
+	if err != nil || e.Type != "echo" || string(e.Payload) != `"say \"hi\""` {
+		t.Fatalf("text line = %+v, %v", e, err)
+	}
+	e, err = taskFromLine([]byte(`{"type": "resize", "payload": [1, 2]}`), formatJSON, "echo")
+	if err != nil || e.Type != "resize" || string(e.Payload) != "[1, 2]" {
+		t.Fatalf("JSON line = %+v, %v", e, err)
+	}
+	if _, err := taskFromLine([]byte(`"just a string"`), formatJSON, "echo"); err == nil {
+		t.Fatal("JSON line that is not an object accepted")
+	}
+}
+
+func TestPublishOne(t *testing.T) {
+	e, err := task.New("echo", []byte(`"hi"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		ch   *fakeChannel
+		want batchReport
+	}{
+		{&fakeChannel{}, batchReport{Published: 1, Confirmed: 1}},
+		{&fakeChannel{nack: map[string]bool{"1": true}}, batchReport{Published: 1, Nacked: 1}},
+		{&fakeChannel{unroutable: map[string]bool{"1": true}}, batchReport{Published: 1, Returned: 1}},
+	}
+	for _, tt := range tests {
+		report, err := publishOne(tt.ch, testTracer(), "", "task_queue", e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report != tt.want {
+			t.Errorf("report = %+v, want %+v", report, tt.want)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// clockLayouts are -at formats for a time of day, meaning its next occurrence
+var clockLayouts = []string{"15:04", "15:04:05"}
+
+// dateLayouts are -at formats naming a full local date and time
+var dateLayouts = []string{"2006-01-02 15:04", "2006-01-02 15:04:05", "2006-01-02T15:04:05"}
+
+// parseAt reads an -at time: RFC 3339, a local date and time, or a time of
+// day, which is its next occurrence after now
+func parseAt(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, s, now.Location()); err == nil {
+			return t, nil
+		}
+	}
+	for _, layout := range clockLayouts {
+		c, err := time.Parse(layout, s)
+		if err != nil {
+			continue
+		}
+		t := time.Date(now.Year(), now.Month(), now.Day(), c.Hour(), c.Minute(), c.Second(), 0, now.Location())
+		if !t.After(now) {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("can't parse -at %q; use RFC 3339, \"2006-01-02 15:04\" or \"15:04\"", s)
+}
+
+// taskDelay is how long to hold a task back for -delay or -at
+func taskDelay(delay time.Duration, at string, now time.Time) (time.Duration, error) {
+	switch {
+	case delay != 0 && at != "":
+		return 0, errors.New("-delay and -at can't be combined")
+	case delay < 0:
+		return 0, errors.New("-delay can't be negative")
+	case at == "":
+		return delay, nil
+	}
+	t, err := parseAt(at, now)
+	if err != nil {
+		return 0, err
+	}
+	if t.Before(now) {
+		return 0, fmt.Errorf("-at %s is in the past", t.Format(time.RFC3339))
+	}
+	return t.Sub(now), nil
+}
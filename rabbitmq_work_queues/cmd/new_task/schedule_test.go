@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseAt(t *testing.T) {
+	loc := time.FixedZone("test", 2*60*60)
+	now := time.Date(2026, 3, 10, 14, 30, 0, 0, loc)
+	for _, tc := range []struct {
+		in   string
+		want time.Time
+	}{
+		{"15:00", time.Date(2026, 3, 10, 15, 0, 0, 0, loc)},
+		// a time of day already passed means tomorrow
+		{"02:00", time.Date(2026, 3, 11, 2, 0, 0, 0, loc)},
+		{"14:30", time.Date(2026, 3, 11, 14, 30, 0, 0, loc)},
+		{"2026-03-12 08:15", time.Date(2026, 3, 12, 8, 15, 0, 0, loc)},
+		{"2026-03-12T08:15:00Z", time.Date(2026, 3, 12, 8, 15, 0, 0, time.UTC)},
+	} {
+		got, err := parseAt(tc.in, now)
+		if err != nil {
+			t.Fatalf("parseAt(%q): %v", tc.in, err)
+		}
+		if !got.Equal(tc.want) {
+			t.Errorf("parseAt(%q) = %s, want %s", tc.in, got, tc.want)
+		}
+	}
+	if _, err := parseAt("tomorrow", now); err == nil {
+		t.Error("parseAt(tomorrow) succeeded")
+	}
+}
+
+func TestTaskDelay(t *testing.T) {
+	now := time.Date(2026, 3, 10, 14, 30, 0, 0, time.UTC)
+	if d, err := taskDelay(10*time.Minute, "", now); err != nil || d != 10*time.Minute {
+		t.Errorf("taskDelay(10m) = %s, %v", d, err)
+	}
+	if d, err := taskDelay(0, "15:00", now); err != nil || d != 30*time.Minute {
+		t.Errorf("taskDelay(at 15:00) = %s, %v", d, err)
+	}
+	if _, err := taskDelay(time.Minute, "15:00", now); err == nil {
+		t.Error("taskDelay accepted both -delay and -at")
+	}
+	if _, err := taskDelay(0, "2026-03-10 14:00", now); err == nil {
+		t.Error("taskDelay accepted a time in the past")
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"rabbitmq_work_queues/task"
+)
+
+// Handler runs one task. decode unmarshals the task payload. ctx is
+// cancelled when shutdown's grace period runs out.
+type Handler func(ctx context.Context, decode func(v any) error) error
+
+// badPayloadError marks tasks whose payload the handler can't decode;
+// retrying them can't succeed
+type badPayloadError struct{ err error }
+
+func (e *badPayloadError) Error() string { return "bad payload: " + e.err.Error() }
+func (e *badPayloadError) Unwrap() error { return e.err }
+
+// isBadPayload reports whether err was caused by an undecodable payload
+func isBadPayload(err error) bool {
+	var bad *badPayloadError
+	return errors.As(err, &bad)
+}
+
+// HandlerFunc adapts a typed function to a Handler, decoding the payload into P
+func HandlerFunc[P any](fn func(ctx context.Context, payload P) error) Handler {
+	return func(ctx context.Context, decode func(v any) error) error {
+		var payload P
+		if err := decode(&payload); err != nil {
+			return &badPayloadError{err}
+		}
+		return fn(ctx, payload)
+	}
+}
+
+// HandlerRegistry maps task types to their handlers
+type HandlerRegistry struct {
+	mu       sync.RWMutex
+	handlers map[string]Handler
+}
+
+// NewHandlerRegistry creates an empty registry
+func NewHandlerRegistry() *HandlerRegistry {
+	return &HandlerRegistry{handlers: make(map[string]Handler)}
+}
+
+// Register adds or replaces the handler for typ
+func (r *HandlerRegistry) Register(typ string, h Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[typ] = h
+}
+
+// Lookup returns the handler for a task type
+func (r *HandlerRegistry) Lookup(typ string) (Handler, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	h, ok := r.handlers[typ]
+	return h, ok
+}
+
+// decodePayload returns the decode func handed to a Handler for e. A
+// missing payload decodes as JSON null.
+func decodePayload(e task.Envelope) func(v any) error {
+	return func(v any) error {
+		if len(e.Payload) == 0 {
+			return json.Unmarshal([]byte("null"), v)
+		}
+		return json.Unmarshal(e.Payload, v)
+	}
+}
+
+// defaultHandlers returns the handlers the worker ships with
+func defaultHandlers() *HandlerRegistry {
+	r := NewHandlerRegistry()
+	// (1 Dot = 1 second)
+	r.Register(task.LegacyType, HandlerFunc(func(ctx context.Context, body string) error {
+		dotCount := bytes.Count([]byte(body), []byte("."))
+		t := time.NewTimer(time.Duration(dotCount) * time.Second)
+		defer t.Stop()
+		select {
+		case <-t.C:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}))
+	return r
+}
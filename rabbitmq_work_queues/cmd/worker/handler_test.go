@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/Ashraful52038/RabbitMq/pkg/tracing"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"rabbitmq_work_queues/task"
+)
+
+// published is a message the worker sent to a queue
+type published struct {
+	queue string
+	msg   amqp.Publishing
+}
+
+// fakePublisher records what the worker publishes, failing with err if set
+type fakePublisher struct {
+	err error
+
+	mu   sync.Mutex
+	msgs []published
+}
+
+func (p *fakePublisher) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	if p.err != nil {
+		return p.err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.msgs = append(p.msgs, published{key, msg})
+	return nil
+}
+
+var errFailed = errors.New("failed")
+
+// dispatchHandlers registers "ok", "fail", which always fails, "wait",
+// which runs until cancelled, and "count", which wants a number
+func dispatchHandlers() *HandlerRegistry {
+	r := NewHandlerRegistry()
+	r.Register("ok", HandlerFunc(func(ctx context.Context, body string) error { return nil }))
+	r.Register("fail", HandlerFunc(func(ctx context.Context, body string) error { return errFailed }))
+	r.Register("wait", HandlerFunc(func(ctx context.Context, body string) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}))
+	r.Register("count", HandlerFunc(func(ctx context.Context, n int) error { return nil }))
+	return r
+}
+
+func newTestWorker(p publisher) *worker {
+	return &worker{
+		ch:          p,
+		tracer:      tracing.NewTracer("worker", nil),
+		handlers:    dispatchHandlers(),
+		queue:       "task_queue",
+		maxAttempts: 3,
+	}
+}
+
+// attempt returns d as attempt n of its task
+func attempt(t *testing.T, d amqp.Delivery, n int) amqp.Delivery {
+	t.Helper()
+	e, err := task.Decode(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Attempt = n
+	if d.Body, err = json.Marshal(e); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestHandleDeadLetters(t *testing.T) {
+	ack := &fakeAcknowledger{}
+	tests := []struct {
+		name, reason string
+		d            amqp.Delivery
+	}{
+		{"unknown type", "unknown_type", delivery(t, ack, 1, "resize", `{"w": 10}`)},
+		{"bad payload", "bad_payload", delivery(t, ack, 1, "count", `"three"`)},
+		{"last attempt", "max_attempts", attempt(t, delivery(t, ack, 1, "fail", `"x"`), 3)},
+		{"bad envelope", "bad_envelope", amqp.Delivery{Acknowledger: ack, DeliveryTag: 1, ContentType: task.ContentType, Body: []byte("{")}},
+		{"bad encoding", "bad_encoding", amqp.Delivery{Acknowledger: ack, DeliveryTag: 1, ContentType: task.ContentType, ContentEncoding: "gzip", Body: []byte("not gzip")}},
+	}
+	for _, tt := range tests {
+		p := &fakePublisher{}
+		newTestWorker(p).handle(context.Background(), tt.d)
+
+		if len(p.msgs) != 1 || p.msgs[0].queue != deadLetterQueue {
+			t.Errorf("%s: published %+v, want one dead letter", tt.name, p.msgs)
+			continue
+		}
+		msg := p.msgs[0].msg
+		if msg.Headers[deadLetterReasonHeader] != tt.reason || msg.Headers[deadLetterErrorHeader] == "" {
+			t.Errorf("%s: headers %v, want reason %s", tt.name, msg.Headers, tt.reason)
+		}
+		// the body is kept as it arrived, still readable with its encoding
+		if string(msg.Body) != string(tt.d.Body) || msg.ContentEncoding != tt.d.ContentEncoding {
+			t.Errorf("%s: dead letter body %q encoding %q", tt.name, msg.Body, msg.ContentEncoding)
+		}
+	}
+	acked, nacked, _ := ack.settled()
+	if len(acked) != len(tests) || len(nacked) != 0 {
+		t.Fatalf("acked %v nacked %v, want every dead-lettered task acked", acked, nacked)
+	}
+}
+
+func TestHandleRetries(t *testing.T) {
+	ack := &fakeAcknowledger{}
+	p := &fakePublisher{}
+	d := delivery(t, ack, 7, "fail", `"x"`)
+	d.Headers = amqp.Table{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}
+	newTestWorker(p).handle(context.Background(), d)
+
+	if len(p.msgs) != 1 || p.msgs[0].queue != "task_queue" {
+		t.Fatalf("published %+v, want the retry on task_queue", p.msgs)
+	}
+	e, err := task.Decode(amqp.Delivery{ContentType: p.msgs[0].msg.ContentType, Body: p.msgs[0].msg.Body})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Type != "fail" || e.Attempt != 2 {
+		t.Fatalf("retry = %+v, want attempt 2", e)
+	}
+	if acked, _, _ := ack.settled(); len(acked) != 1 || acked[0] != 7 {
+		t.Fatalf("acked %v, want the original delivery", acked)
+	}
+}
+
+func TestHandleRequeues(t *testing.T) {
+	// the grace period ran out while the task ran
+	ack := &fakeAcknowledger{}
+	p := &fakePublisher{}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	newTestWorker(p).handle(ctx, delivery(t, ack, 1, "wait", `"x"`))
+
+	// the retry or dead letter could not be published
+	broken := &fakePublisher{err: errors.New("channel closed")}
+	newTestWorker(broken).handle(context.Background(), delivery(t, ack, 2, "fail", `"x"`))
+	newTestWorker(broken).handle(context.Background(), delivery(t, ack, 3, "resize", `"x"`))
+
+	acked, nacked, requeue := ack.settled()
+	if len(acked) != 0 || len(nacked) != 3 {
+		t.Fatalf("acked %v nacked %v, want every task requeued", acked, nacked)
+	}
+	for i, r := range requeue {
+		if !r {
+			t.Errorf("task %d nacked without requeue", nacked[i])
+		}
+	}
+	if len(p.msgs) != 0 {
+		t.Fatalf("published %+v for an interrupted task", p.msgs)
+	}
+}
+
+func TestHandleAcks(t *testing.T) {
+	ack := &fakeAcknowledger{}
+	p := &fakePublisher{}
+	w := newTestWorker(p)
+	w.handle(context.Background(), delivery(t, ack, 1, "ok", `"x"`))
+	w.handle(context.Background(), delivery(t, ack, 2, "count", `3`))
+	// a plain-text task from before envelopes
+	w.handlers.Register(task.LegacyType, HandlerFunc(func(ctx context.Context, body string) error { return nil }))
+	w.handle(context.Background(), amqp.Delivery{Acknowledger: ack, DeliveryTag: 3, ContentType: "text/plain", Body: []byte("hello")})
+
+	if acked, nacked, _ := ack.settled(); len(acked) != 3 || len(nacked) != 0 || len(p.msgs) != 0 {
+		t.Fatalf("acked %v nacked %v published %d", acked, nacked, len(p.msgs))
+	}
+}
+
+func TestHandlerRegistry(t *testing.T) {
+	r := NewHandlerRegistry()
+	if _, ok := r.Lookup("ok"); ok {
+		t.Fatal("empty registry found a handler")
+	}
+	r.Register("ok", HandlerFunc(func(ctx context.Context, body string) error { return errFailed }))
+	r.Register("ok", HandlerFunc(func(ctx context.Context, body string) error { return nil }))
+	h, ok := r.Lookup("ok")
+	if !ok {
+		t.Fatal("registered handler not found")
+	}
+	if err := h(context.Background(), decodePayload(task.Envelope{Payload: []byte(`"x"`)})); err != nil {
+		t.Fatalf("Register did not replace the handler: %v", err)
+	}
+}
+
+func TestHandlerFuncPayloads(t *testing.T) {
+	var got *int
+	h := HandlerFunc(func(ctx context.Context, n *int) error {
+		got = n
+		return nil
+	})
+	// a missing payload decodes as null
+	if err := h(context.Background(), decodePayload(task.Envelope{})); err != nil || got != nil {
+		t.Fatalf("missing payload: %v, %v", got, err)
+	}
+	if err := h(context.Background(), decodePayload(task.Envelope{Payload: []byte("4")})); err != nil || *got != 4 {
+		t.Fatalf("payload 4: %v, %v", got, err)
+	}
+	err := h(context.Background(), decodePayload(task.Envelope{Payload: []byte(`"four"`)}))
+	if !isBadPayload(err) {
+		t.Fatalf("error %v, want a bad payload", err)
+	}
+	if isBadPayload(errFailed) {
+		t.Fatal("handler error taken for a bad payload")
+	}
+}
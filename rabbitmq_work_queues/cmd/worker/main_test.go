@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Ashraful52038/RabbitMq/pkg/tracing"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"rabbitmq_work_queues/task"
+)
+
+// fakeAcknowledger records how each delivery was settled
+type fakeAcknowledger struct {
+	mu      sync.Mutex
+	acked   []uint64
+	nacked  []uint64
+	requeue []bool
+}
+
+func (a *fakeAcknowledger) Ack(tag uint64, multiple bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.acked = append(a.acked, tag)
+	return nil
+}
+
+func (a *fakeAcknowledger) Nack(tag uint64, multiple, requeue bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.nacked = append(a.nacked, tag)
+	a.requeue = append(a.requeue, requeue)
+	return nil
+}
+
+func (a *fakeAcknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+// settled returns the acked and nacked tags and whether each nack requeued
+func (a *fakeAcknowledger) settled() (acked, nacked []uint64, requeue []bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]uint64(nil), a.acked...), append([]uint64(nil), a.nacked...), append([]bool(nil), a.requeue...)
+}
+
+// delivery returns a task delivery settled through ack
+func delivery(t *testing.T, ack amqp.Acknowledger, tag uint64, typ, payload string) amqp.Delivery {
+	t.Helper()
+	e, err := task.New(typ, []byte(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := e.Publishing()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return amqp.Delivery{
+		Acknowledger: ack,
+		DeliveryTag:  tag,
+		ContentType:  msg.ContentType,
+		Type:         msg.Type,
+		Body:         msg.Body,
+	}
+}
+
+// shutdownHandlers registers "wait", which runs until cancelled, and
+// "stuck", which ignores cancellation until release closes
+func shutdownHandlers(started chan<- string, release <-chan struct{}) *HandlerRegistry {
+	r := NewHandlerRegistry()
+	r.Register("wait", HandlerFunc(func(ctx context.Context, body string) error {
+		started <- body
+		<-ctx.Done()
+		return ctx.Err()
+	}))
+	r.Register("stuck", HandlerFunc(func(ctx context.Context, body string) error {
+		started <- body
+		<-release
+		return nil
+	}))
+	return r
+}
+
+func TestShutdown(t *testing.T) {
+	tests := []struct {
+		typ      string
+		finished bool
+	}{
+		// cancelled at the end of the grace period and requeued
+		{"wait", true},
+		// ignores its cancellation, so the worker gives up on it
+		{"stuck", false},
+	}
+	for _, tt := range tests {
+		started := make(chan string, 1)
+		release := make(chan struct{})
+		w := &worker{
+			tracer:      tracing.NewTracer("worker", nil),
+			handlers:    shutdownHandlers(started, release),
+			queue:       "task_queue",
+			maxAttempts: 3,
+		}
+		ack := &fakeAcknowledger{}
+		msgs := make(chan amqp.Delivery, 2)
+		stopping, stop := context.WithCancel(context.Background())
+		taskCtx, cancelTasks := context.WithCancel(context.Background())
+
+		done := w.consume(stopping, taskCtx, msgs)
+		msgs <- delivery(t, ack, 1, tt.typ, `"running"`)
+		<-started
+
+		// the signal arrives: deliveries still in flight are requeued
+		stop()
+		msgs <- delivery(t, ack, 2, tt.typ, `"late"`)
+		close(msgs)
+
+		start := time.Now()
+		finished := awaitTasks(done, cancelTasks, 20*time.Millisecond, 50*time.Millisecond)
+		if finished != tt.finished {
+			t.Fatalf("%s: awaitTasks = %v, want %v", tt.typ, finished, tt.finished)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Fatalf("%s: shutdown took %s", tt.typ, elapsed)
+		}
+
+		_, nacked, requeue := ack.settled()
+		if tt.finished {
+			if len(nacked) != 2 || !requeue[0] || !requeue[1] {
+				t.Fatalf("%s: nacked %v requeue %v, want both tasks requeued", tt.typ, nacked, requeue)
+			}
+		} else {
+			// the running task is left to the channel close
+			close(release)
+			<-done
+			acked, nacked, _ := ack.settled()
+			if len(acked) != 1 || len(nacked) != 1 || nacked[0] != 2 {
+				t.Fatalf("%s: acked %v nacked %v", tt.typ, acked, nacked)
+			}
+		}
+		select {
+		case body := <-started:
+			t.Fatalf("%s: handler ran for %s delivered during shutdown", tt.typ, body)
+		default:
+		}
+	}
+}
+
+func TestAwaitTasksFinishedInGrace(t *testing.T) {
+	done := make(chan struct{})
+	close(done)
+	cancelled := false
+	if !awaitTasks(done, func() { cancelled = true }, time.Second, time.Second) {
+		t.Fatal("finished task reported abandoned")
+	}
+	if cancelled {
+		t.Fatal("task cancelled although it finished within the grace period")
+	}
+}
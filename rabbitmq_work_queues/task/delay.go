@@ -0,0 +1,153 @@
+package task
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Delays describes a set of delay queues that hold tasks back without a
+// broker plugin. Level L is a queue whose TTL is 2^L × Resolution and
+// which dead-letters into level L-1's exchange, level 0 dead-lettering
+// into the deliver exchange bound to the destination queue.
+//
+// A delay of n × Resolution is routed with a key spelling n in binary,
+// one word per level from the highest down, followed by the destination:
+// "0.1.1.task_queue" waits in levels 1 and 0 (3 × Resolution). Each
+// level's exchange sends the message through its queue when its bit is 1
+// and straight on to the next level when it is 0. Every queue has a fixed
+// TTL, so messages expire in the order they arrived and none waits behind
+// a longer delay.
+type Delays struct {
+	// Prefix names the exchanges and queues
+	Prefix string
+	// Resolution is the smallest delay step; delays are rounded to it
+	Resolution time.Duration
+	// Levels sets the longest delay to (2^Levels - 1) × Resolution
+	Levels int
+}
+
+// DefaultDelays returns one-second steps up to about 12 days
+func DefaultDelays() Delays {
+	return Delays{Prefix: "task_delay", Resolution: time.Second, Levels: 20}
+}
+
+// ErrDelayTooLong is returned for delays beyond Delays.Max
+var ErrDelayTooLong = errors.New("delay too long")
+
+// DelayChannel is the part of *amqp.Channel that declares the delay queues
+type DelayChannel interface {
+	ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error
+	ExchangeBind(destination, key, source string, noWait bool, args amqp.Table) error
+	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error
+}
+
+// Max is the longest delay d can hold a task for
+func (d Delays) Max() time.Duration {
+	return d.Resolution * time.Duration(uint64(1)<<d.Levels-1)
+}
+
+// Exchange is the exchange of a level, Levels being the deliver exchange
+func (d Delays) Exchange(level int) string {
+	if level >= d.Levels {
+		return d.Prefix + ".deliver"
+	}
+	return fmt.Sprintf("%s.%02d", d.Prefix, level)
+}
+
+// Queue is the queue tasks wait in at a level
+func (d Delays) Queue(level int) string {
+	return d.Exchange(level) + ".wait"
+}
+
+// validate checks the levels fit a routing key and TTLs are whole milliseconds
+func (d Delays) validate() error {
+	switch {
+	case d.Prefix == "":
+		return errors.New("delays: prefix is required")
+	case d.Resolution < time.Millisecond || d.Resolution%time.Millisecond != 0:
+		return errors.New("delays: resolution must be a whole number of milliseconds")
+	case d.Levels < 1 || d.Levels > 32:
+		return errors.New("delays: levels must be between 1 and 32")
+	case d.Resolution > time.Duration(math.MaxInt64>>d.Levels):
+		return errors.New("delays: longest delay overflows time.Duration")
+	}
+	return nil
+}
+
+// Declare declares every level and binds dest to the deliver exchange.
+// It is idempotent, so publishers can call it on startup.
+func (d Delays) Declare(ch DelayChannel, dest string) error {
+	if err := d.validate(); err != nil {
+		return err
+	}
+	for level := d.Levels; level >= 0; level-- {
+		if err := ch.ExchangeDeclare(d.Exchange(level), amqp.ExchangeTopic, true, false, false, false, nil); err != nil {
+			return fmt.Errorf("declare %s: %w", d.Exchange(level), err)
+		}
+	}
+
+	for level := d.Levels - 1; level >= 0; level-- {
+		// the key word for this level, counting from the highest
+		skip := strings.Repeat("*.", d.Levels-1-level)
+		next := d.Exchange(level - 1)
+		if level == 0 {
+			next = d.Exchange(d.Levels)
+		}
+
+		q := d.Queue(level)
+		ttl := d.Resolution.Milliseconds() << level
+		_, err := ch.QueueDeclare(q, true, false, false, false, amqp.Table{
+			"x-message-ttl":          ttl,
+			"x-dead-letter-exchange": next,
+			// no dead-letter routing key: the original key carries on
+		})
+		if err != nil {
+			return fmt.Errorf("declare %s: %w", q, err)
+		}
+		if err := ch.QueueBind(q, skip+"1.#", d.Exchange(level), false, nil); err != nil {
+			return fmt.Errorf("bind %s: %w", q, err)
+		}
+		if err := ch.ExchangeBind(next, skip+"0.#", d.Exchange(level), false, nil); err != nil {
+			return fmt.Errorf("bind %s: %w", next, err)
+		}
+	}
+
+	key := strings.Repeat("*.", d.Levels) + dest
+	if err := ch.QueueBind(dest, key, d.Exchange(d.Levels), false, nil); err != nil {
+		return fmt.Errorf("bind %s: %w", dest, err)
+	}
+	return nil
+}
+
+// Route returns where to publish a task for dest so it arrives after
+// delay, rounded to Resolution. Delays that round to zero go straight to
+// dest through the default exchange.
+func (d Delays) Route(delay time.Duration, dest string) (exchange, key string, err error) {
+	if err := d.validate(); err != nil {
+		return "", "", err
+	}
+	if delay > d.Max()+d.Resolution/2 {
+		return "", "", fmt.Errorf("%w: %s is over %s", ErrDelayTooLong, delay, d.Max())
+	}
+	steps := (delay + d.Resolution/2) / d.Resolution
+	if steps <= 0 {
+		return "", dest, nil
+	}
+
+	words := make([]string, 0, d.Levels+1)
+	for level := d.Levels - 1; level >= 0; level-- {
+		if steps&(1<<level) != 0 {
+			words = append(words, "1")
+		} else {
+			words = append(words, "0")
+		}
+	}
+	words = append(words, dest)
+	return d.Exchange(d.Levels - 1), strings.Join(words, "."), nil
+}
@@ -0,0 +1,251 @@
+package task
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// fakeBroker runs the topology Declare sets up: topic exchanges, exchange
+// and queue bindings, and queues that dead-letter messages when their TTL
+// runs out. Queues without a TTL record what reaches them.
+type fakeBroker struct {
+	mu        sync.Mutex
+	exchanges map[string]bool
+	queues    map[string]*fakeQueue
+	bindings  []fakeBinding
+}
+
+type fakeBinding struct {
+	source, key, dest string
+	toExchange        bool
+}
+
+type fakeQueue struct {
+	ttl time.Duration
+	dlx string
+	// waiting feeds the expiry loop of a TTL queue
+	waiting chan fakeMessage
+	// arrived is what reached a queue without a TTL
+	arrived []arrival
+}
+
+type fakeMessage struct {
+	key     string
+	body    string
+	expires time.Time
+}
+
+type arrival struct {
+	body string
+	at   time.Time
+}
+
+func newFakeBroker() *fakeBroker {
+	return &fakeBroker{
+		exchanges: make(map[string]bool),
+		queues:    make(map[string]*fakeQueue),
+	}
+}
+
+func (b *fakeBroker) ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	if kind != amqp.ExchangeTopic {
+		return errors.New("only topic exchanges are faked")
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.exchanges[name] = true
+	return nil
+}
+
+func (b *fakeBroker) ExchangeBind(destination, key, source string, noWait bool, args amqp.Table) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.exchanges[destination] || !b.exchanges[source] {
+		return errors.New("no such exchange")
+	}
+	b.bindings = append(b.bindings, fakeBinding{source: source, key: key, dest: destination, toExchange: true})
+	return nil
+}
+
+func (b *fakeBroker) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	q := &fakeQueue{}
+	if ttl, ok := args["x-message-ttl"].(int64); ok {
+		q.ttl = time.Duration(ttl) * time.Millisecond
+		q.dlx, _ = args["x-dead-letter-exchange"].(string)
+		q.waiting = make(chan fakeMessage, 1024)
+		go b.expire(q)
+	}
+	b.queues[name] = q
+	return amqp.Queue{Name: name}, nil
+}
+
+func (b *fakeBroker) QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.queues[name] == nil || !b.exchanges[exchange] {
+		return errors.New("no such queue or exchange")
+	}
+	b.bindings = append(b.bindings, fakeBinding{source: exchange, key: key, dest: name})
+	return nil
+}
+
+// expire dead-letters q's messages in order as their TTL runs out
+func (b *fakeBroker) expire(q *fakeQueue) {
+	for m := range q.waiting {
+		time.Sleep(time.Until(m.expires))
+		b.publish(q.dlx, m.key, m.body)
+	}
+}
+
+// publish routes body like the broker, "" being the default exchange
+func (b *fakeBroker) publish(exchange, key, body string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if exchange == "" {
+		b.enqueue(b.queues[key], key, body)
+		return
+	}
+	b.route(exchange, key, body)
+}
+
+func (b *fakeBroker) route(exchange, key, body string) {
+	for _, bind := range b.bindings {
+		if bind.source != exchange || !topicMatch(bind.key, key) {
+			continue
+		}
+		if bind.toExchange {
+			b.route(bind.dest, key, body)
+		} else {
+			b.enqueue(b.queues[bind.dest], key, body)
+		}
+	}
+}
+
+func (b *fakeBroker) enqueue(q *fakeQueue, key, body string) {
+	if q.waiting == nil {
+		q.arrived = append(q.arrived, arrival{body, time.Now()})
+		return
+	}
+	q.waiting <- fakeMessage{key: key, body: body, expires: time.Now().Add(q.ttl)}
+}
+
+func (b *fakeBroker) arrived(queue string) []arrival {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return slices.Clone(b.queues[queue].arrived)
+}
+
+// topicMatch matches key against a topic pattern with * and #
+func topicMatch(pattern, key string) bool {
+	return matchWords(strings.Split(pattern, "."), strings.Split(key, "."))
+}
+
+func matchWords(p, k []string) bool {
+	if len(p) == 0 {
+		return len(k) == 0
+	}
+	if p[0] == "#" {
+		for i := 0; i <= len(k); i++ {
+			if matchWords(p[1:], k[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(k) == 0 || (p[0] != "*" && p[0] != k[0]) {
+		return false
+	}
+	return matchWords(p[1:], k[1:])
+}
+
+func TestRoute(t *testing.T) {
+	d := Delays{Prefix: "delay", Resolution: time.Second, Levels: 4}
+	for _, tc := range []struct {
+		delay    time.Duration
+		exchange string
+		key      string
+	}{
+		{0, "", "task_queue"},
+		{400 * time.Millisecond, "", "task_queue"},
+		{time.Second, "delay.03", "0.0.0.1.task_queue"},
+		{1600 * time.Millisecond, "delay.03", "0.0.1.0.task_queue"},
+		{11 * time.Second, "delay.03", "1.0.1.1.task_queue"},
+		{15 * time.Second, "delay.03", "1.1.1.1.task_queue"},
+	} {
+		exchange, key, err := d.Route(tc.delay, "task_queue")
+		if err != nil {
+			t.Fatalf("Route(%s): %v", tc.delay, err)
+		}
+		if exchange != tc.exchange || key != tc.key {
+			t.Errorf("Route(%s) = %q %q, want %q %q", tc.delay, exchange, key, tc.exchange, tc.key)
+		}
+	}
+	if _, _, err := d.Route(16*time.Second, "task_queue"); !errors.Is(err, ErrDelayTooLong) {
+		t.Errorf("Route(16s) error = %v, want ErrDelayTooLong", err)
+	}
+}
+
+func TestDelaysDeliverInOrderWithinTolerance(t *testing.T) {
+	d := Delays{Prefix: "delay", Resolution: 10 * time.Millisecond, Levels: 6}
+	// rounding is up to half a step; the rest covers scheduling
+	tolerance := d.Resolution/2 + 25*time.Millisecond
+
+	b := newFakeBroker()
+	if _, err := b.QueueDeclare("task_queue", true, false, false, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Declare(b, "task_queue"); err != nil {
+		t.Fatal(err)
+	}
+
+	tasks := []struct {
+		body  string
+		delay time.Duration
+	}{
+		{"e", 400 * time.Millisecond},
+		{"b", 90 * time.Millisecond},
+		{"d", 250 * time.Millisecond},
+		{"a", 0},
+		{"c1", 160 * time.Millisecond},
+		{"c2", 160 * time.Millisecond},
+		{"f", d.Max()},
+	}
+	want := make(map[string]time.Time)
+	start := time.Now()
+	for _, task := range tasks {
+		exchange, key, err := d.Route(task.delay, "task_queue")
+		if err != nil {
+			t.Fatal(err)
+		}
+		want[task.body] = time.Now().Add(task.delay)
+		b.publish(exchange, key, task.body)
+	}
+
+	deadline := start.Add(d.Max() + time.Second)
+	for len(b.arrived("task_queue")) < len(tasks) {
+		if time.Now().After(deadline) {
+			t.Fatalf("only %d of %d tasks arrived", len(b.arrived("task_queue")), len(tasks))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	var order []string
+	for _, a := range b.arrived("task_queue") {
+		order = append(order, a.body)
+		if late := a.at.Sub(want[a.body]); late < -tolerance || late > tolerance {
+			t.Errorf("task %s arrived %s from its due time, tolerance %s", a.body, late, tolerance)
+		}
+	}
+	// equal delays keep the order they were published in
+	if got := strings.Join(order, " "); got != "a b c1 c2 d e f" {
+		t.Errorf("tasks arrived in order %s", got)
+	}
+}
@@ -0,0 +1,101 @@
+// Package task defines the JSON envelope work queue tasks travel in.
+// new_task publishes envelopes to task_queue and the worker dispatches
+// them on their type.
+package task
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// ContentType marks a message body as an Envelope
+const ContentType = "application/json"
+
+// Version is the envelope format this package writes. Decode rejects
+// newer envelopes; those without a version are version 1.
+const Version = 1
+
+// LegacyType is the type given to plain-text tasks published before
+// envelopes existed; their body becomes a JSON string payload
+const LegacyType = "sleep"
+
+// Envelope wraps one task
+type Envelope struct {
+	Version int             `json:"version"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+	// Attempt counts deliveries to a handler, starting at 1
+	Attempt    int       `json:"attempt"`
+	EnqueuedAt time.Time `json:"enqueued_at"`
+	// TraceID is the trace the task was published under
+	TraceID string `json:"trace_id,omitempty"`
+}
+
+// New returns the first attempt of a task, enqueued now. payload must be
+// JSON; empty means none.
+func New(typ string, payload []byte) (Envelope, error) {
+	if typ == "" {
+		return Envelope{}, errors.New("task type is required")
+	}
+	if len(payload) > 0 && !json.Valid(payload) {
+		return Envelope{}, fmt.Errorf("payload of %s task is not valid JSON", typ)
+	}
+	return Envelope{
+		Version:    Version,
+		Type:       typ,
+		Payload:    json.RawMessage(payload),
+		Attempt:    1,
+		EnqueuedAt: time.Now().UTC(),
+	}, nil
+}
+
+// Publishing encodes e as a persistent message. The Type property repeats
+// the task type so it shows up in the management UI.
+func (e Envelope) Publishing() (amqp.Publishing, error) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return amqp.Publishing{}, err
+	}
+	return amqp.Publishing{
+		DeliveryMode: amqp.Persistent,
+		ContentType:  ContentType,
+		Type:         e.Type,
+		Timestamp:    e.EnqueuedAt,
+		Body:         body,
+	}, nil
+}
+
+// Decode reads the envelope from a decompressed delivery. Plain-text
+// bodies are legacy tasks of LegacyType.
+func Decode(d amqp.Delivery) (Envelope, error) {
+	mediaType, _, _ := mime.ParseMediaType(d.ContentType)
+	if mediaType == "" || mediaType == "text/plain" {
+		payload, err := json.Marshal(string(d.Body))
+		if err != nil {
+			return Envelope{}, err
+		}
+		return Envelope{Version: Version, Type: LegacyType, Payload: payload, Attempt: 1, EnqueuedAt: d.Timestamp}, nil
+	}
+	if mediaType != ContentType {
+		return Envelope{}, fmt.Errorf("unsupported task content type %q", d.ContentType)
+	}
+
+	var e Envelope
+	if err := json.Unmarshal(d.Body, &e); err != nil {
+		return Envelope{}, fmt.Errorf("bad task envelope: %w", err)
+	}
+	if e.Version > Version {
+		return Envelope{}, fmt.Errorf("bad task envelope: version %d is newer than %d", e.Version, Version)
+	}
+	if e.Type == "" {
+		return Envelope{}, errors.New("bad task envelope: missing type")
+	}
+	e.Version = max(e.Version, 1)
+	e.Attempt = max(e.Attempt, 1)
+	return e, nil
+}
@@ -0,0 +1,108 @@
+package task
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestNew(t *testing.T) {
+	e, err := New("resize", []byte(`{"w": 10}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Version != Version || e.Attempt != 1 || e.EnqueuedAt.IsZero() || e.EnqueuedAt.Location() != time.UTC {
+		t.Fatalf("New = %+v", e)
+	}
+	if _, err := New("", nil); err == nil {
+		t.Error("task without a type accepted")
+	}
+	if _, err := New("resize", []byte("{w: 10")); err == nil {
+		t.Error("invalid JSON payload accepted")
+	}
+	if e, err := New("ping", nil); err != nil || e.Payload != nil {
+		t.Errorf("task without a payload = %+v, %v", e, err)
+	}
+}
+
+func TestPublishingRoundTrip(t *testing.T) {
+	e, err := New("resize", []byte(`{"w":10}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Attempt = 2
+	e.TraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+	msg, err := e.Publishing()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.ContentType != ContentType || msg.Type != "resize" || msg.DeliveryMode != amqp.Persistent || !msg.Timestamp.Equal(e.EnqueuedAt) {
+		t.Fatalf("Publishing = %+v", msg)
+	}
+
+	got, err := Decode(amqp.Delivery{ContentType: msg.ContentType + "; charset=utf-8", Body: msg.Body})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Version != e.Version || got.Type != e.Type || string(got.Payload) != string(e.Payload) ||
+		got.Attempt != 2 || !got.EnqueuedAt.Equal(e.EnqueuedAt) || got.TraceID != e.TraceID {
+		t.Fatalf("round trip = %+v, want %+v", got, e)
+	}
+}
+
+func TestDecodeLegacy(t *testing.T) {
+	at := time.Date(2026, 3, 10, 14, 30, 0, 0, time.UTC)
+	for _, contentType := range []string{"", "text/plain", "text/plain; charset=utf-8"} {
+		e, err := Decode(amqp.Delivery{ContentType: contentType, Timestamp: at, Body: []byte(`work..."`)})
+		if err != nil {
+			t.Fatalf("%q: %v", contentType, err)
+		}
+		if e.Type != LegacyType || string(e.Payload) != `"work...\""` || e.Attempt != 1 || !e.EnqueuedAt.Equal(at) {
+			t.Errorf("%q: Decode = %+v", contentType, e)
+		}
+	}
+}
+
+func TestDecodeVersion(t *testing.T) {
+	tests := []struct {
+		body    string
+		version int
+		err     string
+	}{
+		// envelopes from before versioning
+		{`{"type": "ping"}`, 1, ""},
+		{`{"version": 1, "type": "ping"}`, 1, ""},
+		{`{"version": 2, "type": "ping"}`, 0, "version 2 is newer than 1"},
+	}
+	for _, tt := range tests {
+		e, err := Decode(amqp.Delivery{ContentType: ContentType, Body: []byte(tt.body)})
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: error %v, want %q", tt.body, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || e.Version != tt.version || e.Attempt != 1 {
+			t.Errorf("%s: Decode = %+v, %v", tt.body, e, err)
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		contentType, body, err string
+	}{
+		{"application/xml", "<task/>", "unsupported task content type"},
+		{ContentType, "{not json", "bad task envelope"},
+		{ContentType, `{"payload": 1}`, "missing type"},
+	}
+	for _, tt := range tests {
+		_, err := Decode(amqp.Delivery{ContentType: tt.contentType, Body: []byte(tt.body)})
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("Decode(%s %s) error = %v, want %q", tt.contentType, tt.body, err, tt.err)
+		}
+	}
+}
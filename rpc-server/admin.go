@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AdminController is what the admin API steers. rpcServer implements it;
+// tests can use a fake so the API is exercised without a broker.
+type AdminController interface {
+	Status() AdminStatus
+	InFlight() []InFlightRequest
+	Pause() error
+	Resume() error
+	SetPrefetch(count int) error
+	Drain(ctx context.Context) error
+}
+
+// AdminStatus is the body of GET /status
+type AdminStatus struct {
+	Queue       string `json:"queue"`
+	Consuming   bool   `json:"consuming"`
+	Prefetch    int    `json:"prefetch"`
+	MaxPrefetch int    `json:"max_prefetch"`
+	InFlight    int    `json:"in_flight"`
+	Workers     int    `json:"workers"`
+}
+
+// newAdminHandler routes the admin API:
+//
+//	GET  /status                  consumer state
+//	GET  /inflight                admitted requests with correlation id and age
+//	POST /pause                   cancel the consumer
+//	POST /resume                  re-register the consumer
+//	POST /prefetch?count=N        change the prefetch count, up to max_prefetch
+//	POST /drain?timeout=30s       pause and wait for in-flight requests
+//	GET  /debug/pprof/...         pprof, goroutine dumps via /debug/pprof/goroutine?debug=2
+func newAdminHandler(c AdminController, drainTimeout time.Duration) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, c.Status())
+	})
+	mux.HandleFunc("GET /inflight", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, c.InFlight())
+	})
+	mux.HandleFunc("POST /pause", func(w http.ResponseWriter, r *http.Request) {
+		writeResult(w, c.Pause(), c)
+	})
+	mux.HandleFunc("POST /resume", func(w http.ResponseWriter, r *http.Request) {
+		writeResult(w, c.Resume(), c)
+	})
+	mux.HandleFunc("POST /prefetch", func(w http.ResponseWriter, r *http.Request) {
+		count, err := strconv.Atoi(r.URL.Query().Get("count"))
+		if limit := c.Status().MaxPrefetch; err != nil || count < 1 || count > limit {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("count must be an integer between 1 and %d", limit))
+			return
+		}
+		writeResult(w, c.SetPrefetch(count), c)
+	})
+	mux.HandleFunc("POST /drain", func(w http.ResponseWriter, r *http.Request) {
+		timeout := drainTimeout
+		if v := r.URL.Query().Get("timeout"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid timeout %q", v))
+				return
+			}
+			timeout = d
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		writeResult(w, c.Drain(ctx), c)
+	})
+
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	return mux
+}
+
+// writeResult answers a control operation with the resulting status
+func writeResult(w http.ResponseWriter, err error, c AdminController) {
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, c.Status())
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to write admin response: %v", err)
+	}
+}
+
+// adminListen listens on "unix:/path" or a TCP host:port
+func adminListen(addr string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		if err := removeStaleSocket(path); err != nil {
+			return nil, err
+		}
+		return net.Listen("unix", path)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// removeStaleSocket removes a socket left behind by a previous run at
+// path. Anything else there, or a socket still being served, is an error.
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Mode().Type() != os.ModeSocket {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is in use by another server", path)
+	}
+	return os.Remove(path)
+}
+
+// startAdminServer serves the admin API on addr
+func startAdminServer(addr string, c AdminController, drainTimeout time.Duration) (*http.Server, error) {
+	ln, err := adminListen(addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	srv := &http.Server{Handler: newAdminHandler(c, drainTimeout)}
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Printf("Admin server stopped: %v", err)
+		}
+	}()
+	return srv, nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeController stands in for rpcServer so the admin API runs without a broker
+type fakeController struct {
+	status   AdminStatus
+	inflight []InFlightRequest
+	drainCtx context.Context
+}
+
+func (f *fakeController) Status() AdminStatus         { return f.status }
+func (f *fakeController) InFlight() []InFlightRequest { return f.inflight }
+func (f *fakeController) Pause() error                { f.status.Consuming = false; return nil }
+func (f *fakeController) Resume() error               { f.status.Consuming = true; return nil }
+func (f *fakeController) SetPrefetch(count int) error { f.status.Prefetch = count; return nil }
+func (f *fakeController) Drain(ctx context.Context) error {
+	f.drainCtx = ctx
+	f.status.Consuming = false
+	return nil
+}
+
+func doAdmin(t *testing.T, h http.Handler, method, target string, v any) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+	if v != nil && rec.Code == http.StatusOK {
+		if err := json.NewDecoder(rec.Body).Decode(v); err != nil {
+			t.Fatalf("%s %s: decode: %v", method, target, err)
+		}
+	}
+	return rec.Code
+}
+
+func TestAdminPauseResume(t *testing.T) {
+	c := &fakeController{status: AdminStatus{Queue: "rpc_queue", Consuming: true}}
+	h := newAdminHandler(c, time.Second)
+
+	var st AdminStatus
+	if code := doAdmin(t, h, http.MethodPost, "/pause", &st); code != http.StatusOK || st.Consuming {
+		t.Fatalf("pause: code %d, consuming %v", code, st.Consuming)
+	}
+	if code := doAdmin(t, h, http.MethodPost, "/resume", &st); code != http.StatusOK || !st.Consuming {
+		t.Fatalf("resume: code %d, consuming %v", code, st.Consuming)
+	}
+	if code := doAdmin(t, h, http.MethodGet, "/pause", nil); code != http.StatusMethodNotAllowed {
+		t.Fatalf("GET /pause: code %d, want %d", code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestAdminPrefetch(t *testing.T) {
+	c := &fakeController{status: AdminStatus{MaxPrefetch: 50}}
+	h := newAdminHandler(c, time.Second)
+
+	var st AdminStatus
+	if code := doAdmin(t, h, http.MethodPost, "/prefetch?count=25", &st); code != http.StatusOK || st.Prefetch != 25 {
+		t.Fatalf("prefetch: code %d, prefetch %d", code, st.Prefetch)
+	}
+	for _, bad := range []string{"", "abc", "-1", "0", "51"} {
+		if code := doAdmin(t, h, http.MethodPost, "/prefetch?count="+bad, nil); code != http.StatusBadRequest {
+			t.Errorf("prefetch count %q: code %d, want %d", bad, code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestAdminDrainTimeout(t *testing.T) {
+	c := &fakeController{status: AdminStatus{Consuming: true}}
+	h := newAdminHandler(c, time.Minute)
+
+	if code := doAdmin(t, h, http.MethodPost, "/drain?timeout=5s", nil); code != http.StatusOK {
+		t.Fatalf("drain: code %d", code)
+	}
+	deadline, ok := c.drainCtx.Deadline()
+	if !ok || time.Until(deadline) > 5*time.Second {
+		t.Fatalf("drain deadline %v not within 5s", deadline)
+	}
+	if code := doAdmin(t, h, http.MethodPost, "/drain?timeout=soon", nil); code != http.StatusBadRequest {
+		t.Fatalf("bad timeout: code %d, want %d", code, http.StatusBadRequest)
+	}
+}
+
+func TestAdminInFlight(t *testing.T) {
+	c := &fakeController{inflight: []InFlightRequest{
+		{CorrelationID: "abc", Method: "default", State: "processing", Age: "1.5s"},
+	}}
+	h := newAdminHandler(c, time.Second)
+
+	var list []InFlightRequest
+	if code := doAdmin(t, h, http.MethodGet, "/inflight", &list); code != http.StatusOK {
+		t.Fatalf("inflight: code %d", code)
+	}
+	if len(list) != 1 || list[0].CorrelationID != "abc" || list[0].Age != "1.5s" {
+		t.Fatalf("inflight = %+v", list)
+	}
+}
+
+func TestAdminUnixSocket(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "admin.sock")
+	c := &fakeController{status: AdminStatus{Queue: "rpc_queue"}}
+	srv, err := startAdminServer("unix:"+sock, c, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", sock)
+		},
+	}}
+	resp, err := client.Get("http://rpc-server/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var st AdminStatus
+	if err := json.NewDecoder(resp.Body).Decode(&st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Queue != "rpc_queue" {
+		t.Fatalf("status over unix socket = %+v", st)
+	}
+}
+
+func TestAdminListenSocketPath(t *testing.T) {
+	dir := t.TempDir()
+
+	// a mistyped path must not delete the file there
+	file := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(file, []byte("keep"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := adminListen("unix:" + file); err == nil {
+		t.Fatal("listened over a regular file")
+	}
+	if data, err := os.ReadFile(file); err != nil || string(data) != "keep" {
+		t.Fatalf("regular file changed: %q, %v", data, err)
+	}
+
+	// a socket another server is still serving is left alone
+	sock := filepath.Join(dir, "admin.sock")
+	live, err := adminListen("unix:" + sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := adminListen("unix:" + sock); err == nil {
+		t.Fatal("took over a socket in use")
+	}
+
+	// a socket left behind by a previous run is replaced
+	live.(*net.UnixListener).SetUnlinkOnClose(false)
+	live.Close()
+	ln, err := adminListen("unix:" + sock)
+	if err != nil {
+		t.Fatalf("stale socket not replaced: %v", err)
+	}
+	ln.Close()
+}
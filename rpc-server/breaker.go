@@ -0,0 +1,268 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// BreakerState is the state of a circuit breaker
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// halfOpenRetryAfter is the retry-after given while every half-open trial
+// slot is taken. A slot frees as soon as a trial finishes, which is
+// usually well before another OpenTimeout.
+const halfOpenRetryAfter = 100 * time.Millisecond
+
+// Admission is a request Allow let through. It remembers the breaker
+// state it was admitted in, so an outcome that arrives after the state
+// moved on is not counted against the new one.
+type Admission struct {
+	generation uint64
+	trial      bool // admitted as a half-open trial
+}
+
+// CircuitBreaker stops sending requests to a failing handler.
+//
+// Closed: requests flow, failures are counted.
+// Open: requests are rejected until OpenTimeout passes.
+// Half-open: up to HalfOpenMaxRequests trial requests are let through;
+// if they all succeed the breaker closes, any failure opens it again.
+type CircuitBreaker struct {
+	mu sync.Mutex
+
+	consecutiveLimit int
+	rateThreshold    float64
+	minRequests      int
+	window           time.Duration
+	openTimeout      time.Duration
+	halfOpenMax      int
+
+	state       BreakerState
+	consecutive int
+	requests    int
+	failures    int
+	windowStart time.Time
+	openedAt    time.Time
+	trials      int    // half-open requests in flight
+	successes   int    // half-open requests that succeeded
+	generation  uint64 // bumped on every state change
+
+	now func() time.Time
+}
+
+// NewCircuitBreaker creates a closed breaker from the CircuitBreaker config
+func NewCircuitBreaker(c *RPCConfig) *CircuitBreaker {
+	b := &CircuitBreaker{
+		consecutiveLimit: c.CircuitBreaker.ConsecutiveFailures,
+		rateThreshold:    c.CircuitBreaker.FailureRateThreshold,
+		minRequests:      c.CircuitBreaker.MinRequests,
+		window:           c.CircuitBreaker.Window,
+		openTimeout:      c.CircuitBreaker.OpenTimeout,
+		halfOpenMax:      c.CircuitBreaker.HalfOpenMaxRequests,
+		now:              time.Now,
+	}
+	b.windowStart = b.now()
+	return b
+}
+
+// Allow reports whether a request may go through and, if so, returns the
+// admission to report its outcome with. When it may not, the returned
+// duration is how long until the breaker tries again.
+func (b *CircuitBreaker) Allow() (Admission, bool, time.Duration) {
+	if b == nil {
+		return Admission{}, true, 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	if b.state == BreakerOpen {
+		remaining := b.openTimeout - now.Sub(b.openedAt)
+		if remaining > 0 {
+			return Admission{}, false, remaining
+		}
+		b.state = BreakerHalfOpen
+		b.generation++
+		b.trials = 0
+		b.successes = 0
+	}
+
+	a := Admission{generation: b.generation}
+	if b.state == BreakerHalfOpen {
+		if b.trials >= b.halfOpenMax {
+			return Admission{}, false, halfOpenRetryAfter
+		}
+		b.trials++
+		a.trial = true
+	}
+	return a, true, 0
+}
+
+// Record reports the outcome of a request that Allow let through. Outcomes
+// of requests admitted before the last state change are ignored.
+func (b *CircuitBreaker) Record(a Admission, success bool) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if a.generation != b.generation {
+		return
+	}
+	now := b.now()
+	switch b.state {
+	case BreakerHalfOpen:
+		if !success {
+			b.trip(now)
+			return
+		}
+		b.successes++
+		if b.successes >= b.halfOpenMax {
+			b.reset(now)
+		}
+
+	case BreakerClosed:
+		if b.window > 0 && now.Sub(b.windowStart) >= b.window {
+			b.requests = 0
+			b.failures = 0
+			b.windowStart = now
+		}
+		b.requests++
+		if success {
+			b.consecutive = 0
+			return
+		}
+		b.failures++
+		b.consecutive++
+
+		if b.consecutiveLimit > 0 && b.consecutive >= b.consecutiveLimit {
+			b.trip(now)
+			return
+		}
+		if b.rateThreshold > 0 && b.requests >= b.minRequests &&
+			float64(b.failures)/float64(b.requests) >= b.rateThreshold {
+			b.trip(now)
+		}
+	}
+}
+
+// Release gives back what Allow took for a request that ended without an
+// outcome, such as one its caller cancelled, so a half-open trial slot is
+// not lost
+func (b *CircuitBreaker) Release(a Admission) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if a.trial && a.generation == b.generation {
+		b.trials--
+	}
+}
+
+// State returns the current state, moving open to half-open once OpenTimeout passed
+func (b *CircuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen && b.now().Sub(b.openedAt) >= b.openTimeout {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
+
+func (b *CircuitBreaker) trip(now time.Time) {
+	b.state = BreakerOpen
+	b.generation++
+	b.openedAt = now
+}
+
+func (b *CircuitBreaker) reset(now time.Time) {
+	b.state = BreakerClosed
+	b.generation++
+	b.consecutive = 0
+	b.requests = 0
+	b.failures = 0
+	b.windowStart = now
+}
+
+// BreakerSet holds one circuit breaker per handler name
+type BreakerSet struct {
+	mu       sync.Mutex
+	config   *RPCConfig
+	breakers map[string]*CircuitBreaker
+}
+
+// NewBreakerSet returns nil when circuit breaking is disabled
+func NewBreakerSet(c *RPCConfig) *BreakerSet {
+	if !c.CircuitBreaker.Enabled {
+		return nil
+	}
+	return &BreakerSet{
+		config:   c,
+		breakers: make(map[string]*CircuitBreaker),
+	}
+}
+
+// Get returns the breaker for handler, creating it on first use
+func (s *BreakerSet) Get(handler string) *CircuitBreaker {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.breakers[handler]
+	if !ok {
+		b = NewCircuitBreaker(s.config)
+		s.breakers[handler] = b
+	}
+	return b
+}
+
+// States returns the state of every breaker, keyed by handler name
+func (s *BreakerSet) States() map[string]BreakerState {
+	states := make(map[string]BreakerState)
+	if s == nil {
+		return states
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for name, b := range s.breakers {
+		states[name] = b.State()
+	}
+	return states
+}
+
+// sortedKeys returns the handler names of states in order
+func sortedKeys(states map[string]BreakerState) []string {
+	names := make([]string, 0, len(states))
+	for name := range states {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
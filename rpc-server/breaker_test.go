@@ -0,0 +1,172 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func breakerConfig() *RPCConfig {
+	c := DefaultRPCConfig()
+	c.CircuitBreaker.ConsecutiveFailures = 0
+	c.CircuitBreaker.FailureRateThreshold = 0
+	c.CircuitBreaker.MinRequests = 4
+	c.CircuitBreaker.Window = 30 * time.Second
+	c.CircuitBreaker.OpenTimeout = 15 * time.Second
+	c.CircuitBreaker.HalfOpenMaxRequests = 1
+	return c
+}
+
+// newTestBreaker builds a breaker running on clock
+func newTestBreaker(c *RPCConfig, clock *fakeClock) *CircuitBreaker {
+	b := NewCircuitBreaker(c)
+	b.now = clock.Now
+	b.windowStart = clock.Now()
+	return b
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	// Steps: "ok" and "fail" are allowed requests and their outcome,
+	// "hold" is an allowed request still running, "late-ok" and
+	// "late-fail" finish the oldest held request, "deny" must be refused,
+	// "+open" and "+window" advance the clock by OpenTimeout and Window.
+	tests := []struct {
+		name  string
+		setup func(c *RPCConfig)
+		steps []string
+		want  BreakerState
+	}{
+		{
+			name:  "successes reset the consecutive count",
+			setup: func(c *RPCConfig) { c.CircuitBreaker.ConsecutiveFailures = 3 },
+			steps: []string{"fail", "fail", "ok", "fail", "fail"},
+			want:  BreakerClosed,
+		},
+		{
+			name:  "trips on consecutive failures",
+			setup: func(c *RPCConfig) { c.CircuitBreaker.ConsecutiveFailures = 3 },
+			steps: []string{"ok", "fail", "fail", "fail", "deny"},
+			want:  BreakerOpen,
+		},
+		{
+			name:  "failure rate waits for MinRequests",
+			setup: func(c *RPCConfig) { c.CircuitBreaker.FailureRateThreshold = 0.5 },
+			steps: []string{"fail", "fail", "ok"},
+			want:  BreakerClosed,
+		},
+		{
+			name:  "trips on failure rate",
+			setup: func(c *RPCConfig) { c.CircuitBreaker.FailureRateThreshold = 0.5 },
+			steps: []string{"ok", "fail", "ok", "fail", "deny"},
+			want:  BreakerOpen,
+		},
+		{
+			name:  "failure rate counts per window",
+			setup: func(c *RPCConfig) { c.CircuitBreaker.FailureRateThreshold = 0.5 },
+			steps: []string{"fail", "fail", "ok", "+window", "ok", "ok", "ok", "fail"},
+			want:  BreakerClosed,
+		},
+		{
+			name:  "stays open until OpenTimeout",
+			setup: func(c *RPCConfig) { c.CircuitBreaker.ConsecutiveFailures = 1 },
+			steps: []string{"fail", "deny"},
+			want:  BreakerOpen,
+		},
+		{
+			name:  "half-open after OpenTimeout",
+			setup: func(c *RPCConfig) { c.CircuitBreaker.ConsecutiveFailures = 1 },
+			steps: []string{"fail", "+open"},
+			want:  BreakerHalfOpen,
+		},
+		{
+			name: "successful trials close",
+			setup: func(c *RPCConfig) {
+				c.CircuitBreaker.ConsecutiveFailures = 1
+				c.CircuitBreaker.HalfOpenMaxRequests = 2
+			},
+			steps: []string{"fail", "+open", "ok", "ok", "ok"},
+			want:  BreakerClosed,
+		},
+		{
+			name:  "failed trial reopens",
+			setup: func(c *RPCConfig) { c.CircuitBreaker.ConsecutiveFailures = 1 },
+			steps: []string{"fail", "+open", "fail", "deny"},
+			want:  BreakerOpen,
+		},
+		{
+			name:  "trials limited while half-open",
+			setup: func(c *RPCConfig) { c.CircuitBreaker.ConsecutiveFailures = 1 },
+			steps: []string{"fail", "+open", "hold", "deny"},
+			want:  BreakerHalfOpen,
+		},
+		{
+			name:  "success admitted before opening does not close",
+			setup: func(c *RPCConfig) { c.CircuitBreaker.ConsecutiveFailures = 1 },
+			steps: []string{"hold", "fail", "+open", "hold", "late-ok", "deny"},
+			want:  BreakerHalfOpen,
+		},
+		{
+			name:  "failure admitted before closing does not reopen",
+			setup: func(c *RPCConfig) { c.CircuitBreaker.ConsecutiveFailures = 1 },
+			steps: []string{"hold", "fail", "+open", "ok", "late-fail"},
+			want:  BreakerClosed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := breakerConfig()
+			tt.setup(c)
+			clock := newFakeClock()
+			b := newTestBreaker(c, clock)
+
+			var held []Admission
+			for i, step := range tt.steps {
+				switch step {
+				case "+open":
+					clock.Advance(c.CircuitBreaker.OpenTimeout)
+				case "+window":
+					clock.Advance(c.CircuitBreaker.Window)
+				case "deny":
+					if _, ok, _ := b.Allow(); ok {
+						t.Fatalf("step %d: request allowed in state %s", i, b.State())
+					}
+				case "late-ok", "late-fail":
+					b.Record(held[0], step == "late-ok")
+					held = held[1:]
+				default:
+					a, ok, _ := b.Allow()
+					if !ok {
+						t.Fatalf("step %d: request denied in state %s", i, b.State())
+					}
+					if step == "hold" {
+						held = append(held, a)
+					} else {
+						b.Record(a, step == "ok")
+					}
+				}
+			}
+			if got := b.State(); got != tt.want {
+				t.Fatalf("state = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerRetryAfter(t *testing.T) {
+	c := breakerConfig()
+	c.CircuitBreaker.ConsecutiveFailures = 1
+	clock := newFakeClock()
+	b := newTestBreaker(c, clock)
+
+	a, _, _ := b.Allow()
+	b.Record(a, false)
+	clock.Advance(10 * time.Second)
+	if _, _, retryAfter := b.Allow(); retryAfter != 5*time.Second {
+		t.Fatalf("open retry after = %s, want the 5s left of OpenTimeout", retryAfter)
+	}
+
+	clock.Advance(5 * time.Second)
+	b.Allow() // takes the only trial slot
+	if _, _, retryAfter := b.Allow(); retryAfter != halfOpenRetryAfter {
+		t.Fatalf("half-open retry after = %s, want %s", retryAfter, halfOpenRetryAfter)
+	}
+}
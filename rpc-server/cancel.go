@@ -0,0 +1,77 @@
+package main
+
+import (
+	"log"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// listenForCancels consumes cancel messages from the control exchange.
+// Clients publish one carrying a request's correlation id when they stop
+// waiting for its reply.
+func (s *rpcServer) listenForCancels() error {
+	exchange := s.config.RPC.ControlExchange
+	err := s.ch.ExchangeDeclare(
+		exchange,
+		"fanout", // every server instance hears every cancel
+		true,     // durable
+		false,    // auto-deleted
+		false,    // internal
+		false,    // no-wait
+		nil,
+	)
+	if err != nil {
+		return err
+	}
+	q, err := s.ch.QueueDeclare("", false, true, true, false, nil)
+	if err != nil {
+		return err
+	}
+	if err := s.ch.QueueBind(q.Name, "", exchange, false, nil); err != nil {
+		return err
+	}
+	msgs, err := s.ch.Consume(q.Name, "", true, true, false, false, nil)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for m := range msgs {
+			if m.CorrelationId == "" {
+				continue
+			}
+			if s.cancelRequest(m.CorrelationId) {
+				log.Printf("Cancelled by caller (correlation id %q)", m.CorrelationId)
+			}
+		}
+	}()
+	return nil
+}
+
+// cancelRequest cancels the handler of the request with correlationID, or
+// marks it to be skipped if it is still queued. It reports whether the
+// request was found.
+func (s *rpcServer) cancelRequest(correlationID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	found := false
+	for _, r := range s.inflight {
+		if r.CorrelationID != correlationID {
+			continue
+		}
+		found = true
+		r.cancelled = true
+		if r.cancel != nil {
+			r.cancel()
+		}
+	}
+	return found
+}
+
+// skipCancelled acks a request whose caller gave up before it was
+// answered. Its breaker learns nothing, but gets its trial slot back.
+func (s *rpcServer) skipCancelled(d amqp091.Delivery, breaker *CircuitBreaker, admission Admission) {
+	breaker.Release(admission)
+	s.metrics.CancelledTotal.Add(1)
+	d.Ack(false)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+// cancelHandlers registers "echo", and "wait", which signals started and
+// then runs until its request is cancelled
+func cancelHandlers(started chan<- string) *HandlerRegistry {
+	r := echoHandlers()
+	r.Register("wait", HandlerFunc(func(ctx context.Context, body string) (string, error) {
+		started <- body
+		<-ctx.Done()
+		return "", ctx.Err()
+	}))
+	return r
+}
+
+func TestCancelWhileQueued(t *testing.T) {
+	c := testConfig()
+	c.RPC.MaxWorkers = 1
+	started := make(chan string, 1)
+	s, ch := newTestServer(t, c, cancelHandlers(started))
+	s.startWorkers()
+
+	// the only worker is busy, so the second request waits in the queue
+	submit(s, request(ch, 1, "wait", "busy"))
+	<-started
+	submit(s, request(ch, 2, "wait", "queued"))
+
+	if s.cancelRequest("corr-unknown") {
+		t.Fatal("cancelled a request that was never received")
+	}
+	if !s.cancelRequest("corr-2") {
+		t.Fatal("queued request not found")
+	}
+	s.cancelRequest("corr-1")
+
+	waitFor(t, "both requests settled", func() bool { return ch.isAcked(1) && ch.isAcked(2) })
+	select {
+	case body := <-started:
+		t.Fatalf("handler ran for cancelled request %q", body)
+	default:
+	}
+	if n := len(ch.replies("corr-2")); n != 0 {
+		t.Fatalf("sent %d replies to a cancelled caller", n)
+	}
+	if n := s.metrics.CancelledTotal.Load(); n != 2 {
+		t.Fatalf("CancelledTotal = %d, want 2", n)
+	}
+}
+
+func TestCancelWhileProcessing(t *testing.T) {
+	c := testConfig()
+	c.CircuitBreaker.Enabled = true
+	started := make(chan string, 1)
+	s, ch := newTestServer(t, c, cancelHandlers(started))
+	s.startWorkers()
+
+	submit(s, request(ch, 1, "wait", "x"))
+	<-started
+	if got := s.InFlight(); len(got) != 1 || got[0].State != "processing" {
+		t.Fatalf("in flight = %+v, want one processing request", got)
+	}
+	s.cancelRequest("corr-1")
+
+	waitFor(t, "cancelled request acked", func() bool { return ch.isAcked(1) })
+	if n := len(ch.replies("corr-1")); n != 0 {
+		t.Fatalf("sent %d replies to a cancelled caller", n)
+	}
+	// the handler's context error is not the handler's fault
+	b := s.breakers.Get("wait")
+	b.mu.Lock()
+	requests := b.requests
+	b.mu.Unlock()
+	if requests != 0 {
+		t.Fatalf("breaker recorded %d outcomes for a cancelled request", requests)
+	}
+}
+
+func TestCancelDuringHalfOpen(t *testing.T) {
+	c := testConfig()
+	c.CircuitBreaker.Enabled = true
+	c.CircuitBreaker.ConsecutiveFailures = 1
+	c.CircuitBreaker.HalfOpenMaxRequests = 1
+	started := make(chan string, 1)
+	r := cancelHandlers(started)
+	s, ch := newTestServer(t, c, r)
+	s.startWorkers()
+
+	// one handler, "wait", whose breaker is half-open
+	clock := newFakeClock()
+	b := s.breakers.Get("wait")
+	b.now = clock.Now
+	a, _, _ := b.Allow()
+	b.Record(a, false)
+	clock.Advance(c.CircuitBreaker.OpenTimeout)
+
+	// more cancelled trials than there are trial slots
+	for tag := uint64(1); tag <= 3; tag++ {
+		if !submit(s, request(ch, tag, "wait", "trial")) {
+			t.Fatalf("trial %d refused; cancelled trials kept their slots", tag)
+		}
+		<-started
+		s.cancelRequest(fmt.Sprint("corr-", tag))
+		waitFor(t, "cancelled trial acked", func() bool { return ch.isAcked(tag) })
+	}
+	if state := b.State(); state != BreakerHalfOpen {
+		t.Fatalf("breaker %s after cancelled trials, want half-open", state)
+	}
+
+	// a trial that finishes still decides the breaker
+	r.Register("wait", HandlerFunc(func(ctx context.Context, body string) (string, error) {
+		return body, nil
+	}))
+	if !submit(s, request(ch, 4, "wait", "trial")) {
+		t.Fatal("trial refused after cancelled trials")
+	}
+	waitFor(t, "trial answered", func() bool { return ch.isAcked(4) })
+	if state := b.State(); state != BreakerClosed {
+		t.Fatalf("breaker %s after a successful trial, want closed", state)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const usage = `Usage: rpcadmin [-addr unix:/tmp/rpc-server-admin.sock] <command>
+
+Commands:
+  status                 show consumer state
+  inflight               list in-flight requests
+  pause                  stop consuming
+  resume                 start consuming again
+  prefetch <count>       change the prefetch count
+  drain [timeout]        pause and wait for in-flight requests (e.g. 30s)
+  goroutines             dump all goroutine stacks
+  pprof <profile> [secs] fetch a pprof profile (heap, profile, allocs, ...)
+`
+
+// newClient returns an HTTP client and base URL for a unix socket or TCP address
+func newClient(addr string) (*http.Client, string) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		return &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", path)
+				},
+			},
+		}, "http://rpc-server"
+	}
+	return &http.Client{}, "http://" + addr
+}
+
+func main() {
+	addr := flag.String("addr", "unix:/tmp/rpc-server-admin.sock", "admin API address")
+	timeout := flag.Duration("timeout", 2*time.Minute, "request timeout")
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	method, path := http.MethodGet, ""
+	query := url.Values{}
+	switch args[0] {
+	case "status", "inflight":
+		path = "/" + args[0]
+	case "pause", "resume":
+		method, path = http.MethodPost, "/"+args[0]
+	case "prefetch":
+		if len(args) < 2 {
+			log.Fatalf("prefetch needs a count")
+		}
+		method, path = http.MethodPost, "/prefetch"
+		query.Set("count", args[1])
+	case "drain":
+		method, path = http.MethodPost, "/drain"
+		if len(args) > 1 {
+			query.Set("timeout", args[1])
+		}
+	case "goroutines":
+		path = "/debug/pprof/goroutine"
+		query.Set("debug", "2")
+	case "pprof":
+		if len(args) < 2 {
+			log.Fatalf("pprof needs a profile name")
+		}
+		path = "/debug/pprof/" + args[1]
+		if len(args) > 2 {
+			query.Set("seconds", args[2])
+		}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	client, base := newClient(*addr)
+	client.Timeout = *timeout
+
+	u := base + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		log.Fatalf("Failed to build request: %v", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Fatalf("Failed to reach admin API at %s: %v", *addr, err)
+	}
+	defer resp.Body.Close()
+
+	if _, err := io.Copy(os.Stdout, resp.Body); err != nil {
+		log.Fatalf("Failed to read response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		os.Exit(1)
+	}
+}
@@ -5,9 +5,16 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/Ashraful52038/RabbitMq/pkg/compress"
 	"github.com/rabbitmq/amqp091-go"
 )
 
+// Policies for requests that arrive without a ReplyTo property
+const (
+	NoReplyToProcess = "process"
+	NoReplyToReject  = "reject"
+)
+
 //RPCConfig holds all configuration for RPC server
 
 type RPCConfig struct {
@@ -34,6 +41,20 @@ type RPCConfig struct {
 		Exclusive  bool
 		NoWait     bool
 		Arguments  amqp091.Table
+		// MaxPriority sets x-max-priority on the queue (0 = plain FIFO queue)
+		MaxPriority uint8
+		// Rejected requests (panics, timeouts) are dead-lettered here
+		// instead of being dropped; "" (the default) disables it.
+		//
+		// Setting it adds x-dead-letter-exchange to the queue arguments,
+		// and RabbitMQ refuses to redeclare an existing queue with other
+		// arguments (PRECONDITION_FAILED). To enable it on a running
+		// queue, delete and recreate the queue while it is empty, or
+		// leave this empty and apply the exchange with a policy:
+		//   rabbitmqctl set_policy rpc-dlx '^rpc_queue$' '{"dead-letter-exchange":"rpc_dlx"}' --apply-to queues
+		// The same goes for MaxPriority, which can only be set at declare time.
+		DeadLetterExchange string
+		DeadLetterQueue    string
 	}
 
 	// Consumer Configuration
@@ -51,6 +72,9 @@ type RPCConfig struct {
 		PrefetchCount int
 		PrefetchSize  int
 		Global        bool
+		// MaxPrefetchCount is the most the admin API may raise PrefetchCount
+		// to. It sizes the job queues, since serve must never block on them.
+		MaxPrefetchCount int
 	}
 
 	// RPC Specific Configuration
@@ -62,6 +86,54 @@ type RPCConfig struct {
 		LogLevel       string
 		EnableMetrics  bool
 		MetricsPort    int
+		// Requests with Priority >= HighPriority may use the ReservedWorkers
+		// slice of MaxWorkers, which lower priority requests never get
+		HighPriority    uint8
+		ReservedWorkers int
+		// NoReplyTo decides what happens to requests without ReplyTo:
+		// "process" runs them fire-and-forget, "reject" dead-letters them
+		NoReplyTo string
+		// ControlExchange carries cancel messages from clients; empty
+		// disables cancellation
+		ControlExchange string
+	}
+
+	// Compression Configuration
+	Compression struct {
+		Encoding            string // "gzip", "zstd" or "" to send replies uncompressed
+		Threshold           int    // replies smaller than this are not compressed
+		MaxDecompressedSize int64  // requests expanding past this are rejected
+	}
+
+	// Admin API Configuration
+	Admin struct {
+		Enabled      bool
+		Address      string // "unix:/path/to.sock" or "127.0.0.1:9091"
+		DrainTimeout time.Duration
+	}
+
+	// Rate Limiting Configuration
+	RateLimit struct {
+		Enabled     bool
+		KeySource   string // "user_id", "app_id" or "header"
+		KeyHeader   string // header name used when KeySource is "header"
+		GlobalRate  float64
+		GlobalBurst int
+		PerKeyRate  float64
+		PerKeyBurst int
+		IdleTTL     time.Duration
+		MaxKeys     int // per-key buckets kept at most; further keys share one overflow bucket
+	}
+
+	// Circuit Breaker Configuration (one breaker per handler)
+	CircuitBreaker struct {
+		Enabled              bool
+		ConsecutiveFailures  int     // open after this many failures in a row (0 = off)
+		FailureRateThreshold float64 // open when failures/requests in Window reach this (0 = off)
+		MinRequests          int     // requests needed in Window before the rate applies
+		Window               time.Duration
+		OpenTimeout          time.Duration // time spent open before going half-open
+		HalfOpenMaxRequests  int           // trial requests let through while half-open
 	}
 }
 
@@ -88,6 +160,11 @@ func DefaultRPCConfig() *RPCConfig {
 	config.Queue.Exclusive = false
 	config.Queue.NoWait = false
 	config.Queue.Arguments = amqp091.Table{}
+	config.Queue.MaxPriority = 0
+	// Dead-lettering changes the queue arguments, so it is opt-in; see
+	// Queue.DeadLetterExchange before enabling it on an existing queue
+	config.Queue.DeadLetterExchange = ""
+	config.Queue.DeadLetterQueue = "rpc_queue.dead"
 
 	// Consumer Configuration Defaults
 	config.Consumer.Tag = "rpc_server"
@@ -106,6 +183,47 @@ func DefaultRPCConfig() *RPCConfig {
 	config.QoS.PrefetchCount = 1
 	config.QoS.PrefetchSize = 0
 	config.QoS.Global = false
+	config.QoS.MaxPrefetchCount = 100
+
+	// Requests without ReplyTo are rejected unless configured otherwise
+	config.RPC.NoReplyTo = NoReplyToReject
+
+	// Clients publish cancels for abandoned calls here
+	config.RPC.ControlExchange = "rpc_control"
+
+	// Priority Scheduling Defaults (only used with Queue.MaxPriority)
+	config.RPC.HighPriority = 5
+	config.RPC.ReservedWorkers = 0
+
+	// Compression Defaults
+	config.Compression.Encoding = compress.Gzip
+	config.Compression.Threshold = 1024
+	config.Compression.MaxDecompressedSize = compress.DefaultMaxSize
+
+	// Admin API Defaults (local only)
+	config.Admin.Enabled = false
+	config.Admin.Address = "unix:/tmp/rpc-server-admin.sock"
+	config.Admin.DrainTimeout = 30 * time.Second
+
+	// Rate Limiting Defaults (0 rate = unlimited)
+	config.RateLimit.Enabled = false
+	config.RateLimit.KeySource = RateLimitKeyUserID
+	config.RateLimit.KeyHeader = "x-client-id"
+	config.RateLimit.GlobalRate = 0
+	config.RateLimit.GlobalBurst = 0
+	config.RateLimit.PerKeyRate = 0
+	config.RateLimit.PerKeyBurst = 0
+	config.RateLimit.IdleTTL = 10 * time.Minute
+	config.RateLimit.MaxKeys = 10000
+
+	// Circuit Breaker Defaults
+	config.CircuitBreaker.Enabled = true
+	config.CircuitBreaker.ConsecutiveFailures = 5
+	config.CircuitBreaker.FailureRateThreshold = 0.5
+	config.CircuitBreaker.MinRequests = 20
+	config.CircuitBreaker.Window = 30 * time.Second
+	config.CircuitBreaker.OpenTimeout = 15 * time.Second
+	config.CircuitBreaker.HalfOpenMaxRequests = 1
 
 	return config
 
@@ -118,6 +236,7 @@ func DevelopmentRPCConfig() *RPCConfig {
 	config.RPC.LogLevel = "debug"
 	config.RPC.MaxWorkers = 5
 	config.RPC.ProcessTimeout = 30 * time.Second
+	config.Admin.Enabled = true
 	return config
 }
 
@@ -130,9 +249,15 @@ func ProductionRPCConfig() *RPCConfig {
 	config.RPC.ProcessTimeout = 10 * time.Second
 	config.RPC.MaxRetries = 5
 	config.RPC.EnableMetrics = true
+	config.RPC.MetricsPort = 9090
 	config.RPC.LogLevel = "warn"
 	config.RabbitMQ.MaxReconnect = 10
 	config.RabbitMQ.ReconnectDelay = 5 * time.Second
+	config.RateLimit.Enabled = true
+	config.RateLimit.GlobalRate = 1000
+	config.RateLimit.GlobalBurst = 2000
+	config.RateLimit.PerKeyRate = 100
+	config.RateLimit.PerKeyBurst = 200
 	return config
 }
 
@@ -174,6 +299,30 @@ func (c *RPCConfig) GetConnectionURL() string {
 	)
 }
 
+// QueueArguments returns the queue arguments including x-max-priority
+// and x-dead-letter-exchange
+func (c *RPCConfig) QueueArguments() amqp091.Table {
+	args := amqp091.Table{}
+	for k, v := range c.Queue.Arguments {
+		args[k] = v
+	}
+	if c.Queue.MaxPriority > 0 {
+		args["x-max-priority"] = int32(c.Queue.MaxPriority)
+	}
+	if c.Queue.DeadLetterExchange != "" {
+		args["x-dead-letter-exchange"] = c.Queue.DeadLetterExchange
+	}
+	return args
+}
+
+// CompressionOptions returns the options used to compress replies
+func (c *RPCConfig) CompressionOptions() compress.Options {
+	return compress.Options{
+		Encoding:  c.Compression.Encoding,
+		Threshold: c.Compression.Threshold,
+	}
+}
+
 // Validate checks if configuration is valid
 func (c *RPCConfig) Validate() error {
 	if c.RabbitMQ.Username == "" {
@@ -191,5 +340,80 @@ func (c *RPCConfig) Validate() error {
 	if c.RPC.MaxWorkers <= 0 {
 		return fmt.Errorf("MaxWorkers must be positive")
 	}
+	// an unlimited prefetch would let the broker overrun the job queues
+	if c.QoS.PrefetchCount <= 0 {
+		return fmt.Errorf("PrefetchCount must be positive")
+	}
+	if c.QoS.MaxPrefetchCount < c.QoS.PrefetchCount {
+		return fmt.Errorf("MaxPrefetchCount cannot be below PrefetchCount")
+	}
+	if c.Queue.DeadLetterExchange != "" && c.Queue.DeadLetterQueue == "" {
+		return fmt.Errorf("dead letter queue cannot be empty when a dead letter exchange is set")
+	}
+	switch c.RPC.NoReplyTo {
+	case NoReplyToProcess, NoReplyToReject:
+	default:
+		return fmt.Errorf("unknown NoReplyTo policy %q", c.RPC.NoReplyTo)
+	}
+	if c.RPC.ReservedWorkers < 0 || c.RPC.ReservedWorkers >= c.RPC.MaxWorkers {
+		return fmt.Errorf("ReservedWorkers must be between 0 and MaxWorkers-1")
+	}
+	if c.Queue.MaxPriority > 0 && c.RPC.HighPriority > c.Queue.MaxPriority {
+		return fmt.Errorf("HighPriority cannot exceed the queue's MaxPriority")
+	}
+	if c.RateLimit.Enabled {
+		switch c.RateLimit.KeySource {
+		case RateLimitKeyUserID, RateLimitKeyAppID:
+		case RateLimitKeyHeader:
+			if c.RateLimit.KeyHeader == "" {
+				return fmt.Errorf("rate limit key header cannot be empty")
+			}
+		default:
+			return fmt.Errorf("unknown rate limit key source %q", c.RateLimit.KeySource)
+		}
+		if c.RateLimit.GlobalRate < 0 || c.RateLimit.PerKeyRate < 0 {
+			return fmt.Errorf("rate limits cannot be negative")
+		}
+		if c.RateLimit.GlobalRate > 0 && c.RateLimit.GlobalBurst < 1 {
+			return fmt.Errorf("GlobalBurst must be at least 1")
+		}
+		if c.RateLimit.PerKeyRate > 0 && c.RateLimit.PerKeyBurst < 1 {
+			return fmt.Errorf("PerKeyBurst must be at least 1")
+		}
+		if c.RateLimit.PerKeyRate > 0 && c.RateLimit.MaxKeys < 1 {
+			return fmt.Errorf("rate limit MaxKeys must be at least 1")
+		}
+	}
+	if c.CircuitBreaker.Enabled {
+		if c.CircuitBreaker.ConsecutiveFailures <= 0 && c.CircuitBreaker.FailureRateThreshold <= 0 {
+			return fmt.Errorf("circuit breaker needs ConsecutiveFailures or FailureRateThreshold")
+		}
+		if c.CircuitBreaker.FailureRateThreshold > 1 {
+			return fmt.Errorf("FailureRateThreshold must be between 0 and 1")
+		}
+		if c.CircuitBreaker.FailureRateThreshold > 0 && c.CircuitBreaker.Window <= 0 {
+			return fmt.Errorf("circuit breaker Window must be positive")
+		}
+		if c.CircuitBreaker.OpenTimeout <= 0 {
+			return fmt.Errorf("circuit breaker OpenTimeout must be positive")
+		}
+		if c.CircuitBreaker.HalfOpenMaxRequests <= 0 {
+			return fmt.Errorf("HalfOpenMaxRequests must be positive")
+		}
+	}
+	switch c.Compression.Encoding {
+	case "", compress.Gzip, compress.Zstd:
+	default:
+		return fmt.Errorf("unknown compression encoding %q", c.Compression.Encoding)
+	}
+	if c.Compression.MaxDecompressedSize <= 0 {
+		return fmt.Errorf("MaxDecompressedSize must be positive")
+	}
+	if c.Admin.Enabled && c.Admin.Address == "" {
+		return fmt.Errorf("admin address cannot be empty")
+	}
+	if c.RPC.EnableMetrics && c.RPC.MetricsPort <= 0 {
+		return fmt.Errorf("MetricsPort must be set when metrics are enabled")
+	}
 	return nil
 }
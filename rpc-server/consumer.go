@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Resume starts consuming the RPC queue. It is a no-op if already consuming.
+func (s *rpcServer) Resume() error {
+	s.consumeMu.Lock()
+	defer s.consumeMu.Unlock()
+
+	if s.consuming {
+		return nil
+	}
+
+	msgs, err := s.ch.Consume(
+		s.config.Queue.Name,
+		s.consumerTag,
+		s.config.Consumer.AutoAck,
+		s.config.Consumer.Exclusive,
+		s.config.Consumer.NoLocal,
+		s.config.Consumer.NoWait,
+		s.config.Consumer.Args,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to register consumer: %w", err)
+	}
+
+	s.served = make(chan struct{})
+	s.consuming = true
+	go s.serve(msgs, s.served)
+	log.Printf("Consuming from %s (prefetch %d)", s.config.Queue.Name, s.prefetch)
+	return nil
+}
+
+// Pause cancels the consumer. Deliveries the broker already sent are
+// still admitted, so Pause returns once nothing more will arrive.
+func (s *rpcServer) Pause() error {
+	s.consumeMu.Lock()
+	defer s.consumeMu.Unlock()
+
+	if !s.consuming {
+		return nil
+	}
+	if err := s.ch.Cancel(s.consumerTag, false); err != nil {
+		return fmt.Errorf("failed to cancel consumer: %w", err)
+	}
+	<-s.served
+	s.consuming = false
+	log.Printf("Paused consuming from %s", s.config.Queue.Name)
+	return nil
+}
+
+// SetPrefetch changes the prefetch count. The broker applies QoS to new
+// consumers only, so a running consumer is cancelled and re-registered.
+// The count must fit the job queues, between 1 and MaxPrefetchCount.
+func (s *rpcServer) SetPrefetch(count int) error {
+	if count < 1 || count > s.maxPrefetch() {
+		return fmt.Errorf("prefetch count must be between 1 and %d", s.maxPrefetch())
+	}
+
+	s.consumeMu.Lock()
+	wasConsuming := s.consuming
+	s.consumeMu.Unlock()
+
+	if err := s.Pause(); err != nil {
+		return err
+	}
+
+	s.consumeMu.Lock()
+	err := s.ch.Qos(count, s.config.QoS.PrefetchSize, s.config.QoS.Global)
+	if err == nil {
+		s.prefetch = count
+	}
+	s.consumeMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to set QoS: %w", err)
+	}
+
+	if wasConsuming {
+		return s.Resume()
+	}
+	return nil
+}
+
+// maxPrefetch is the largest prefetch the job queues can absorb
+func (s *rpcServer) maxPrefetch() int {
+	return cap(s.high)
+}
+
+// Drain stops consuming and waits for every admitted request to be
+// answered. The server stays paused afterwards until Resume is called.
+func (s *rpcServer) Drain(ctx context.Context) error {
+	if err := s.Pause(); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		s.mu.Lock()
+		remaining := len(s.inflight)
+		s.mu.Unlock()
+		if remaining == 0 {
+			log.Printf("Drained")
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("drain incomplete, %d requests in flight: %w", remaining, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// Status reports the consumer state for the admin API
+func (s *rpcServer) Status() AdminStatus {
+	s.consumeMu.Lock()
+	consuming, prefetch := s.consuming, s.prefetch
+	s.consumeMu.Unlock()
+
+	s.mu.Lock()
+	inflight := len(s.inflight)
+	s.mu.Unlock()
+
+	return AdminStatus{
+		Queue:       s.config.Queue.Name,
+		Consuming:   consuming,
+		Prefetch:    prefetch,
+		MaxPrefetch: s.maxPrefetch(),
+		InFlight:    inflight,
+		Workers:     s.config.RPC.MaxWorkers,
+	}
+}
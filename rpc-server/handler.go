@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// DefaultHandler serves requests that don't set the Type property
+const DefaultHandler = "default"
+
+// Handler processes a request. decode unmarshals the request body with the
+// codec picked by the request's ContentType, and the returned value is
+// encoded with the same codec. ctx is cancelled when the request runs past
+// RPC.ProcessTimeout.
+type Handler func(ctx context.Context, decode func(v any) error) (any, error)
+
+// badRequestError marks errors caused by the request rather than the handler
+type badRequestError struct{ err error }
+
+func (e *badRequestError) Error() string { return "bad request: " + e.err.Error() }
+func (e *badRequestError) Unwrap() error { return e.err }
+
+// isBadRequest reports whether err was caused by an undecodable request
+func isBadRequest(err error) bool {
+	var bad *badRequestError
+	return errors.As(err, &bad)
+}
+
+// HandlerFunc adapts a typed function to a Handler, decoding the request into Req
+func HandlerFunc[Req, Resp any](fn func(ctx context.Context, req Req) (Resp, error)) Handler {
+	return func(ctx context.Context, decode func(v any) error) (any, error) {
+		var req Req
+		if err := decode(&req); err != nil {
+			return nil, &badRequestError{err}
+		}
+		return fn(ctx, req)
+	}
+}
+
+// HandlerRegistry maps the AMQP Type property of a request to its handler
+type HandlerRegistry struct {
+	mu       sync.RWMutex
+	handlers map[string]Handler
+}
+
+// NewHandlerRegistry creates an empty registry
+func NewHandlerRegistry() *HandlerRegistry {
+	return &HandlerRegistry{handlers: make(map[string]Handler)}
+}
+
+// Register adds or replaces the handler for name
+func (r *HandlerRegistry) Register(name string, h Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[name] = h
+}
+
+// Lookup returns the handler for a request Type and the name it is registered as
+func (r *HandlerRegistry) Lookup(typ string) (Handler, string, bool) {
+	if typ == "" {
+		typ = DefaultHandler
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	h, ok := r.handlers[typ]
+	return h, typ, ok
+}
+
+// defaultHandlers returns the handlers the server ships with
+func defaultHandlers() *HandlerRegistry {
+	r := NewHandlerRegistry()
+	r.Register(DefaultHandler, HandlerFunc(func(ctx context.Context, body string) (string, error) {
+		// Simulate processing
+		return "Processed: " + body, nil
+	}))
+	registerMathService(r, mathService{})
+	return r
+}
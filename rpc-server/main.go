@@ -5,8 +5,8 @@ import (
     "os"
     "os/signal"
     "syscall"
-    "time"
     
+    "github.com/Ashraful52038/RabbitMq/pkg/tracing"
     "github.com/rabbitmq/amqp091-go"
 )
 
@@ -41,6 +41,25 @@ func main() {
     log.Printf("Queue: %s", config.Queue.Name)
     log.Printf("QoS Prefetch: %d", config.QoS.PrefetchCount)
     log.Printf("Max Workers: %d", config.RPC.MaxWorkers)
+    log.Printf("Requests without ReplyTo: %s", config.RPC.NoReplyTo)
+    if config.Queue.MaxPriority > 0 {
+        log.Printf("Priority: max %d, %d workers reserved for priority >= %d",
+            config.Queue.MaxPriority,
+            config.RPC.ReservedWorkers,
+            config.RPC.HighPriority)
+    }
+    if config.RateLimit.Enabled {
+        log.Printf("Rate Limit: global %.0f/s, per %s %.0f/s",
+            config.RateLimit.GlobalRate,
+            config.RateLimit.KeySource,
+            config.RateLimit.PerKeyRate)
+    }
+    if config.CircuitBreaker.Enabled {
+        log.Printf("Circuit Breaker: open after %d failures or %.0f%% errors, retry after %s",
+            config.CircuitBreaker.ConsecutiveFailures,
+            config.CircuitBreaker.FailureRateThreshold*100,
+            config.CircuitBreaker.OpenTimeout)
+    }
     log.Println("==============================================")
     
     // Connect to RabbitMQ
@@ -68,90 +87,59 @@ func main() {
         log.Fatalf("Failed to set QoS: %v", err)
     }
     
+    // Declare dead letter exchange and queue for poison requests
+    if config.Queue.DeadLetterExchange != "" {
+        if err := declareDeadLetter(ch, config); err != nil {
+            log.Fatalf("Failed to declare dead letter queue: %v", err)
+        }
+    }
+    
     // Declare queue
-    q, err := ch.QueueDeclare(
+    _, err = ch.QueueDeclare(
         config.Queue.Name,
         config.Queue.Durable,
         config.Queue.AutoDelete,
         config.Queue.Exclusive,
         config.Queue.NoWait,
-        config.Queue.Arguments,
+        config.QueueArguments(),
     )
     if err != nil {
         log.Fatalf("Failed to declare queue: %v", err)
     }
     
-    // Create worker pool
-    workerPool := make(chan struct{}, config.RPC.MaxWorkers)
-    for i := 0; i < config.RPC.MaxWorkers; i++ {
-        workerPool <- struct{}{}
-    }
+    tracer := tracing.FromEnv("rpc-server")
+    defer tracer.Close()
     
-    // Consume messages
-    msgs, err := ch.Consume(
-        q.Name,
-        config.Consumer.Tag,
-        config.Consumer.AutoAck,
-        config.Consumer.Exclusive,
-        config.Consumer.NoLocal,
-        config.Consumer.NoWait,
-        config.Consumer.Args,
-    )
-    if err != nil {
-        log.Fatalf("Failed to register consumer: %v", err)
+    server := newRPCServer(config, ch, defaultHandlers(), tracer)
+    server.watchReturns()
+    if config.RPC.ControlExchange != "" {
+        if err := server.listenForCancels(); err != nil {
+            log.Fatalf("Failed to listen for cancels: %v", err)
+        }
+    }
+    if config.RPC.EnableMetrics {
+        metricsServer := startMetricsServer(config.RPC.MetricsPort, server)
+        defer metricsServer.Close()
+        log.Printf("Metrics on :%d/metrics, health on :%d/health",
+            config.RPC.MetricsPort, config.RPC.MetricsPort)
     }
     
-    log.Println("RPC Server started. Waiting for requests...")
+    if config.Admin.Enabled {
+        adminServer, err := startAdminServer(config.Admin.Address, server, config.Admin.DrainTimeout)
+        if err != nil {
+            log.Fatalf("Failed to start admin API: %v", err)
+        }
+        defer adminServer.Close()
+        log.Printf("Admin API on %s", config.Admin.Address)
+    }
     
     // Process messages
-    go func() {
-        for msg := range msgs {
-            <-workerPool // Acquire worker
-            
-            go func(d amqp091.Delivery) {
-                defer func() {
-                    workerPool <- struct{}{} // Release worker
-                }()
-                
-                log.Printf("Received: %s", d.Body)
-                
-                // Process with timeout
-                responseCh := make(chan []byte, 1)
-                
-                go func() {
-                    // Simulate processing
-                    response := []byte("Processed: " + string(d.Body))
-                    responseCh <- response
-                }()
-                
-                // Wait for response or timeout
-                select {
-                case response := <-responseCh:
-                    // Send response
-                    err = ch.Publish(
-                        "",
-                        d.ReplyTo,
-                        false,
-                        false,
-                        amqp091.Publishing{
-                            ContentType:   "text/plain",
-                            CorrelationId: d.CorrelationId,
-                            Body:          response,
-                        },
-                    )
-                    if err != nil {
-                        log.Printf("Failed to send response: %v", err)
-                    }
-                    
-                    d.Ack(false)
-                    
-                case <-time.After(config.RPC.ProcessTimeout):
-                    log.Printf("Request timeout")
-                    d.Nack(false, false)
-                }
-            }(msg)
-        }
-    }()
+    server.startWorkers()
+    if err := server.Resume(); err != nil {
+        log.Fatalf("Failed to start consuming: %v", err)
+    }
+    
+    log.Println("RPC Server started. Waiting for requests...")
     
     // Graceful shutdown
     quit := make(chan os.Signal, 1)
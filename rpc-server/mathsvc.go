@@ -0,0 +1,22 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Ashraful52038/RabbitMq/pkg/mathsvc"
+)
+
+//go:generate go run github.com/Ashraful52038/RabbitMq/pkg/cmd/rpcgen -src ../pkg/mathsvc -type MathService -server mathsvc_gen.go
+
+// mathService implements mathsvc.MathService
+type mathService struct{}
+
+// Fib computes fib(n) for n up to mathsvc.MaxUint64N, the most the
+// uint64 Result can hold
+func (mathService) Fib(ctx context.Context, req mathsvc.FibRequest) (mathsvc.FibResponse, error) {
+	if req.N < 0 || req.N > mathsvc.MaxUint64N {
+		return mathsvc.FibResponse{}, &badRequestError{fmt.Errorf("n must be between 0 and %d", mathsvc.MaxUint64N)}
+	}
+	return mathsvc.FibResponse{N: req.N, Result: mathsvc.Fib(req.N).Uint64()}, nil
+}
@@ -0,0 +1,11 @@
+// Code generated by rpcgen. DO NOT EDIT.
+
+package main
+
+import "github.com/Ashraful52038/RabbitMq/pkg/mathsvc"
+
+// registerMathService adds impl's methods to r under their method names,
+// which clients send in the AMQP Type property
+func registerMathService(r *HandlerRegistry, impl mathsvc.MathService) {
+	r.Register("Fib", HandlerFunc(impl.Fib))
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/Ashraful52038/RabbitMq/pkg/compress"
+)
+
+// Metrics holds the server counters exposed on /metrics
+type Metrics struct {
+	RequestsTotal        atomic.Int64
+	RepliesTotal         atomic.Int64
+	ErrorsTotal          atomic.Int64
+	TimeoutsTotal        atomic.Int64
+	RateLimitedTotal     atomic.Int64
+	CircuitRejectedTotal atomic.Int64
+	PanicsTotal          atomic.Int64
+	NoReplyToTotal       atomic.Int64
+	ReturnedRepliesTotal atomic.Int64
+	CancelledTotal       atomic.Int64
+}
+
+// WritePrometheus writes the counters and breaker states in Prometheus text format
+func (m *Metrics) WritePrometheus(w io.Writer, breakers *BreakerSet) {
+	counters := []struct {
+		name  string
+		help  string
+		value int64
+	}{
+		{"rpc_requests_total", "Requests received.", m.RequestsTotal.Load()},
+		{"rpc_replies_total", "Successful replies sent.", m.RepliesTotal.Load()},
+		{"rpc_errors_total", "Requests answered with a handler error.", m.ErrorsTotal.Load()},
+		{"rpc_timeouts_total", "Requests that ran past the process timeout.", m.TimeoutsTotal.Load()},
+		{"rpc_rate_limited_total", "Requests rejected by the rate limiter.", m.RateLimitedTotal.Load()},
+		{"rpc_circuit_rejected_total", "Requests rejected by an open circuit breaker.", m.CircuitRejectedTotal.Load()},
+		{"rpc_panics_total", "Handler panics recovered and dead-lettered.", m.PanicsTotal.Load()},
+		{"rpc_no_reply_to_total", "Requests received without a ReplyTo property.", m.NoReplyToTotal.Load()},
+		{"rpc_returned_replies_total", "Replies returned by the broker as unroutable.", m.ReturnedRepliesTotal.Load()},
+		{"rpc_cancelled_total", "Requests cancelled by their caller before being answered.", m.CancelledTotal.Load()},
+		{"rpc_compressed_total", "Bodies compressed on publish.", compress.Stats.Compressed.Load()},
+		{"rpc_compression_bytes_saved_total", "Bytes saved by compression.", compress.BytesSaved()},
+		{"rpc_decompressed_total", "Bodies decompressed on delivery.", compress.Stats.Decompressed.Load()},
+		{"rpc_decompression_rejected_total", "Bodies rejected for exceeding the decompressed size limit.", compress.Stats.Rejected.Load()},
+	}
+	for _, c := range counters {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", c.name, c.help, c.name, c.name, c.value)
+	}
+
+	seconds := []struct {
+		name  string
+		help  string
+		nanos int64
+	}{
+		{"rpc_compression_seconds_total", "Time spent compressing bodies.", compress.Stats.CompressNanos.Load()},
+		{"rpc_decompression_seconds_total", "Time spent decompressing bodies.", compress.Stats.DecompressNanos.Load()},
+	}
+	for _, c := range seconds {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %g\n", c.name, c.help, c.name, c.name, time.Duration(c.nanos).Seconds())
+	}
+
+	states := breakers.States()
+	fmt.Fprintln(w, "# HELP rpc_circuit_breaker_state Circuit breaker state per handler (0=closed, 1=open, 2=half-open).")
+	fmt.Fprintln(w, "# TYPE rpc_circuit_breaker_state gauge")
+	for _, name := range sortedKeys(states) {
+		fmt.Fprintf(w, "rpc_circuit_breaker_state{handler=%q} %d\n", name, states[name])
+	}
+}
+
+// healthStatus is the body of the /health endpoint
+type healthStatus struct {
+	Status   string            `json:"status"`
+	Breakers map[string]string `json:"breakers"`
+}
+
+// health reports "degraded" while any handler's circuit is not closed
+func health(breakers *BreakerSet) healthStatus {
+	h := healthStatus{Status: "ok", Breakers: make(map[string]string)}
+	for name, state := range breakers.States() {
+		h.Breakers[name] = state.String()
+		if state != BreakerClosed {
+			h.Status = "degraded"
+		}
+	}
+	return h
+}
+
+// startMetricsServer serves /metrics and /health on port
+func startMetricsServer(port int, s *rpcServer) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		s.metrics.WritePrometheus(w, s.breakers)
+	})
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(health(s.breakers))
+	})
+
+	srv := &http.Server{Addr: fmt.Sprintf(":%d", port), Handler: mux}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("Metrics server stopped: %v", err)
+		}
+	}()
+	return srv
+}
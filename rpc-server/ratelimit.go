@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// Rate limit key sources
+const (
+	RateLimitKeyUserID = "user_id"
+	RateLimitKeyAppID  = "app_id"
+	RateLimitKeyHeader = "header"
+)
+
+// anonymousKey is used for callers that don't identify themselves
+const anonymousKey = "anonymous"
+
+// tokenBucket refills at rate tokens per second up to burst tokens
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   now,
+	}
+}
+
+// refill adds the tokens earned since the last call
+func (b *tokenBucket) refill(now time.Time) {
+	if now.After(b.last) {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+	}
+}
+
+// wait returns how long until one token is available (0 if available now)
+func (b *tokenBucket) wait() time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// RateLimiter applies a global token bucket and one bucket per caller key.
+// Keys can come from the caller, so at most maxKeys buckets are kept and
+// keys beyond that share the overflow bucket.
+type RateLimiter struct {
+	mu          sync.Mutex
+	global      *tokenBucket
+	perKey      map[string]*tokenBucket
+	overflow    *tokenBucket
+	perKeyRate  float64
+	perKeyBurst int
+	maxKeys     int
+	idleTTL     time.Duration
+	lastSweep   time.Time
+	now         func() time.Time
+}
+
+// NewRateLimiter creates a limiter from the RateLimit section of the config
+func NewRateLimiter(c *RPCConfig) *RateLimiter {
+	l := &RateLimiter{
+		perKey:      make(map[string]*tokenBucket),
+		perKeyRate:  c.RateLimit.PerKeyRate,
+		perKeyBurst: c.RateLimit.PerKeyBurst,
+		maxKeys:     c.RateLimit.MaxKeys,
+		idleTTL:     c.RateLimit.IdleTTL,
+		now:         time.Now,
+	}
+	l.lastSweep = l.now()
+	if c.RateLimit.GlobalRate > 0 {
+		l.global = newTokenBucket(c.RateLimit.GlobalRate, c.RateLimit.GlobalBurst, l.lastSweep)
+	}
+	return l
+}
+
+// Allow takes a token for key from both the per-key and the global bucket.
+// A token is only taken when both buckets have one, otherwise the returned
+// duration says how long the caller should wait before retrying.
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	if key == "" {
+		key = anonymousKey
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	var bucket *tokenBucket
+	if l.perKeyRate > 0 {
+		bucket = l.bucket(key, now)
+		bucket.refill(now)
+	}
+	if l.global != nil {
+		l.global.refill(now)
+	}
+
+	var retryAfter time.Duration
+	if bucket != nil {
+		retryAfter = bucket.wait()
+	}
+	if l.global != nil {
+		retryAfter = max(retryAfter, l.global.wait())
+	}
+	if retryAfter > 0 {
+		return false, retryAfter
+	}
+
+	if bucket != nil {
+		bucket.tokens--
+	}
+	if l.global != nil {
+		l.global.tokens--
+	}
+	return true, 0
+}
+
+// bucket returns the bucket for key, creating it while fewer than maxKeys
+// exist and handing out the shared overflow bucket after that
+func (l *RateLimiter) bucket(key string, now time.Time) *tokenBucket {
+	if b := l.perKey[key]; b != nil {
+		return b
+	}
+	if len(l.perKey) < l.maxKeys {
+		b := newTokenBucket(l.perKeyRate, l.perKeyBurst, now)
+		l.perKey[key] = b
+		return b
+	}
+	if l.overflow == nil {
+		l.overflow = newTokenBucket(l.perKeyRate, l.perKeyBurst, now)
+	}
+	return l.overflow
+}
+
+// sweep drops per-key buckets that have been idle (and therefore full) for idleTTL
+func (l *RateLimiter) sweep(now time.Time) {
+	if l.idleTTL <= 0 || now.Sub(l.lastSweep) < l.idleTTL {
+		return
+	}
+	for key, b := range l.perKey {
+		if now.Sub(b.last) >= l.idleTTL {
+			delete(l.perKey, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// rateLimitKey extracts the caller identity used for per-key limits
+func rateLimitKey(d amqp091.Delivery, source, header string) string {
+	switch source {
+	case RateLimitKeyUserID:
+		return d.UserId
+	case RateLimitKeyAppID:
+		return d.AppId
+	case RateLimitKeyHeader:
+		switch v := d.Headers[header].(type) {
+		case nil:
+		case string:
+			return v
+		case []byte:
+			// longstr headers arrive as []byte from some clients
+			return string(v)
+		default:
+			return fmt.Sprint(v)
+		}
+	}
+	return ""
+}
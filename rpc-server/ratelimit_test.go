@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// fakeClock is a manually advanced clock for the now seams
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) Now() time.Time          { return c.t }
+func (c *fakeClock) Advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{t: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+// newTestLimiter builds a limiter whose buckets all run on clock
+func newTestLimiter(c *RPCConfig, clock *fakeClock) *RateLimiter {
+	l := NewRateLimiter(c)
+	l.now = clock.Now
+	l.lastSweep = clock.Now()
+	if l.global != nil {
+		l.global.last = clock.Now()
+	}
+	return l
+}
+
+func rateLimitConfig() *RPCConfig {
+	c := DefaultRPCConfig()
+	c.RateLimit.Enabled = true
+	return c
+}
+
+// within reports whether got is within a millisecond of want
+func within(got, want time.Duration) bool {
+	d := got - want
+	return d > -time.Millisecond && d < time.Millisecond
+}
+
+func TestRateLimiterBurstAndRefill(t *testing.T) {
+	c := rateLimitConfig()
+	c.RateLimit.PerKeyRate = 10
+	c.RateLimit.PerKeyBurst = 3
+	clock := newFakeClock()
+	l := newTestLimiter(c, clock)
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow("a"); !ok {
+			t.Fatalf("request %d within burst denied", i+1)
+		}
+	}
+	ok, retryAfter := l.Allow("a")
+	if ok || !within(retryAfter, 100*time.Millisecond) {
+		t.Fatalf("over burst: ok %v, retry after %s, want denied for 100ms", ok, retryAfter)
+	}
+	// other callers have their own bucket
+	if ok, _ := l.Allow("b"); !ok {
+		t.Fatal("another key was limited")
+	}
+
+	clock.Advance(100 * time.Millisecond)
+	if ok, _ := l.Allow("a"); !ok {
+		t.Fatal("refilled token denied")
+	}
+	if ok, _ := l.Allow("a"); ok {
+		t.Fatal("allowed past the refilled token")
+	}
+
+	// refill stops at the burst
+	clock.Advance(time.Hour)
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow("a"); !ok {
+			t.Fatalf("request %d after idle denied", i+1)
+		}
+	}
+	if ok, _ := l.Allow("a"); ok {
+		t.Fatal("bucket refilled past its burst")
+	}
+}
+
+func TestRateLimiterRetryAfter(t *testing.T) {
+	c := rateLimitConfig()
+	c.RateLimit.GlobalRate = 2
+	c.RateLimit.GlobalBurst = 1
+	c.RateLimit.PerKeyRate = 5
+	c.RateLimit.PerKeyBurst = 1
+	clock := newFakeClock()
+	l := newTestLimiter(c, clock)
+
+	if ok, _ := l.Allow("a"); !ok {
+		t.Fatal("first request denied")
+	}
+	// both buckets are empty; the slower global one decides
+	ok, retryAfter := l.Allow("a")
+	if ok || !within(retryAfter, 500*time.Millisecond) {
+		t.Fatalf("ok %v, retry after %s, want denied for 500ms", ok, retryAfter)
+	}
+
+	clock.Advance(200 * time.Millisecond)
+	ok, retryAfter = l.Allow("a")
+	if ok || !within(retryAfter, 300*time.Millisecond) {
+		t.Fatalf("after 200ms: ok %v, retry after %s, want denied for 300ms", ok, retryAfter)
+	}
+
+	// a denied request takes no token from either bucket
+	clock.Advance(300 * time.Millisecond)
+	if ok, _ := l.Allow("a"); !ok {
+		t.Fatal("request denied once the retry-after passed")
+	}
+}
+
+func TestRateLimiterSweepsIdleBuckets(t *testing.T) {
+	c := rateLimitConfig()
+	c.RateLimit.PerKeyRate = 1
+	c.RateLimit.PerKeyBurst = 1
+	c.RateLimit.IdleTTL = time.Minute
+	clock := newFakeClock()
+	l := newTestLimiter(c, clock)
+
+	l.Allow("a")
+	l.Allow("b")
+	clock.Advance(30 * time.Second)
+	l.Allow("b")
+	clock.Advance(45 * time.Second)
+	l.Allow("c")
+
+	if len(l.perKey) != 2 || l.perKey["a"] != nil {
+		t.Fatalf("buckets after sweep: %v, want b and c", l.perKey)
+	}
+}
+
+func TestRateLimiterCapsKeys(t *testing.T) {
+	c := rateLimitConfig()
+	c.RateLimit.PerKeyRate = 1
+	c.RateLimit.PerKeyBurst = 1
+	c.RateLimit.IdleTTL = time.Minute
+	c.RateLimit.MaxKeys = 2
+	clock := newFakeClock()
+	l := newTestLimiter(c, clock)
+
+	l.Allow("a")
+	l.Allow("b")
+	// a caller rotating its key only ever gets the one overflow bucket
+	for i := 0; i < 100; i++ {
+		ok, _ := l.Allow(fmt.Sprint("rotated-", i))
+		if ok != (i == 0) {
+			t.Fatalf("rotated key %d: allowed %v", i, ok)
+		}
+	}
+	if len(l.perKey) != 2 {
+		t.Fatalf("%d buckets kept, MaxKeys is 2", len(l.perKey))
+	}
+	if ok, _ := l.Allow("a"); ok {
+		t.Fatal("a's own bucket refilled by the overflow")
+	}
+
+	// once the buckets are swept new keys get their own again
+	clock.Advance(2 * time.Minute)
+	l.Allow("c")
+	if l.perKey["c"] == nil || len(l.perKey) != 1 {
+		t.Fatalf("buckets after sweep: %v, want c", l.perKey)
+	}
+}
+
+func TestRateLimitKey(t *testing.T) {
+	d := amqp091.Delivery{
+		UserId: "alice",
+		AppId:  "billing",
+		Headers: amqp091.Table{
+			"x-client-id": "client-1",
+			"x-raw-id":    []byte("hi"),
+			"x-num-id":    int32(42),
+		},
+	}
+	tests := []struct {
+		source, header, want string
+	}{
+		{RateLimitKeyUserID, "", "alice"},
+		{RateLimitKeyAppID, "", "billing"},
+		{RateLimitKeyHeader, "x-client-id", "client-1"},
+		{RateLimitKeyHeader, "x-raw-id", "hi"},
+		{RateLimitKeyHeader, "x-num-id", "42"},
+		{RateLimitKeyHeader, "x-missing", ""},
+	}
+	for _, tt := range tests {
+		if got := rateLimitKey(d, tt.source, tt.header); got != tt.want {
+			t.Errorf("rateLimitKey(%s %s) = %q, want %q", tt.source, tt.header, got, tt.want)
+		}
+	}
+}
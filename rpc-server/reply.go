@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Ashraful52038/RabbitMq/pkg/codec"
+	"github.com/Ashraful52038/RabbitMq/pkg/compress"
+	"github.com/Ashraful52038/RabbitMq/pkg/tracing"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// Headers set on error replies so clients can tell them apart from results
+const (
+	ErrorCodeHeader      = "x-error-code"
+	RetryAfterHeader     = "x-retry-after-ms"
+	SupportedTypesHeader = "x-supported-content-types"
+)
+
+// Error codes sent in the x-error-code header
+const (
+	ErrCodeRateLimited   = "rate_limited"
+	ErrCodeCircuitOpen   = "circuit_open"
+	ErrCodeUnknownMethod = "unknown_method"
+	ErrCodeHandler       = "handler_error"
+	ErrCodeBadRequest    = "bad_request"
+	ErrCodeUnsupported   = "unsupported_content_type"
+	ErrCodeBadEncoding   = "bad_content_encoding"
+	ErrCodeInternal      = "internal_error"
+)
+
+// publish sends msg to the caller's reply queue under a producer span.
+// Replies are mandatory so a vanished reply queue comes back through
+// watchReturns instead of being dropped silently. Requests without
+// ReplyTo are fire-and-forget and get no reply.
+func (s *rpcServer) publish(ctx context.Context, d amqp091.Delivery, msg amqp091.Publishing) error {
+	if d.ReplyTo == "" {
+		return nil
+	}
+	msg.CorrelationId = d.CorrelationId
+	_, span := s.tracer.StartPublish(ctx, &msg, "", d.ReplyTo)
+	defer span.End()
+
+	err := s.ch.Publish("", d.ReplyTo, true, false, msg)
+	span.RecordError(err)
+	return err
+}
+
+// sendReply publishes a successful response to the caller's reply queue,
+// compressing the body when it is large enough and the caller accepts it
+func (s *rpcServer) sendReply(ctx context.Context, d amqp091.Delivery, contentType string, body []byte) error {
+	msg := amqp091.Publishing{
+		ContentType: contentType,
+		Body:        body,
+	}
+	opts := compress.Negotiate(s.config.CompressionOptions(), d.Headers)
+	if err := compress.Publishing(&msg, opts); err != nil {
+		return fmt.Errorf("failed to compress response: %w", err)
+	}
+	return s.publish(ctx, d, msg)
+}
+
+// sendError publishes an error response with the given code and extra headers
+func (s *rpcServer) sendError(ctx context.Context, d amqp091.Delivery, code, msg string, headers amqp091.Table) error {
+	if headers == nil {
+		headers = amqp091.Table{}
+	}
+	headers[ErrorCodeHeader] = code
+
+	if span := tracing.SpanFromContext(ctx); span != nil {
+		span.SetAttribute("rpc.error_code", code)
+		span.RecordError(errors.New(msg))
+	}
+	return s.publish(ctx, d, amqp091.Publishing{
+		ContentType: "text/plain",
+		Headers:     headers,
+		Body:        []byte(msg),
+	})
+}
+
+// reject answers a request with an error before it reaches a worker
+func (s *rpcServer) reject(ctx context.Context, d amqp091.Delivery, code, msg string, headers amqp091.Table) {
+	if err := s.sendError(ctx, d, code, msg, headers); err != nil {
+		log.Printf("Failed to send %s response: %v", code, err)
+	}
+	d.Ack(false)
+}
+
+// retryAfterMillis rounds d for the retry-after header, never below 1ms
+func retryAfterMillis(d time.Duration) time.Duration {
+	d = d.Round(time.Millisecond)
+	if d < time.Millisecond {
+		d = time.Millisecond
+	}
+	return d
+}
+
+// rejectRateLimited tells the caller to back off for retryAfter
+func (s *rpcServer) rejectRateLimited(ctx context.Context, d amqp091.Delivery, retryAfter time.Duration) {
+	retryAfter = retryAfterMillis(retryAfter)
+	s.reject(ctx, d, ErrCodeRateLimited,
+		fmt.Sprintf("rate limited: retry after %s", retryAfter),
+		amqp091.Table{RetryAfterHeader: retryAfter.Milliseconds()},
+	)
+}
+
+// rejectCircuitOpen tells the caller that handler is failing and not being called
+func (s *rpcServer) rejectCircuitOpen(ctx context.Context, d amqp091.Delivery, handler string, retryAfter time.Duration) {
+	retryAfter = retryAfterMillis(retryAfter)
+	s.reject(ctx, d, ErrCodeCircuitOpen,
+		fmt.Sprintf("circuit open for %q: retry after %s", handler, retryAfter),
+		amqp091.Table{RetryAfterHeader: retryAfter.Milliseconds()},
+	)
+}
+
+// rejectUnsupported rejects a request whose content type has no codec,
+// listing the supported types in the body and a header
+func (s *rpcServer) rejectUnsupported(ctx context.Context, d amqp091.Delivery, err error) {
+	var unsupported *codec.UnsupportedError
+	headers := amqp091.Table{}
+	if errors.As(err, &unsupported) {
+		headers[SupportedTypesHeader] = strings.Join(unsupported.Supported, ", ")
+	}
+	s.reject(ctx, d, ErrCodeUnsupported, err.Error(), headers)
+}
+
+// watchReturns counts and logs replies the broker could not route,
+// typically because the client's exclusive reply queue is gone
+func (s *rpcServer) watchReturns() {
+	returns := s.ch.NotifyReturn(make(chan amqp091.Return, 16))
+	go func() {
+		for r := range returns {
+			s.metrics.ReturnedRepliesTotal.Add(1)
+			log.Printf("Reply returned: %d %s (correlation id %q, reply queue %q)",
+				r.ReplyCode, r.ReplyText, r.CorrelationId, r.RoutingKey)
+		}
+	}()
+}
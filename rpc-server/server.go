@@ -0,0 +1,427 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Ashraful52038/RabbitMq/pkg/codec"
+	"github.com/Ashraful52038/RabbitMq/pkg/compress"
+	"github.com/Ashraful52038/RabbitMq/pkg/tracing"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// amqpChannel is the part of *amqp091.Channel the server uses, so tests
+// can run it against a fake
+type amqpChannel interface {
+	Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp091.Table) (<-chan amqp091.Delivery, error)
+	Cancel(consumer string, noWait bool) error
+	Qos(prefetchCount, prefetchSize int, global bool) error
+	Publish(exchange, key string, mandatory, immediate bool, msg amqp091.Publishing) error
+	NotifyReturn(c chan amqp091.Return) chan amqp091.Return
+	ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp091.Table) error
+	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp091.Table) (amqp091.Queue, error)
+	QueueBind(name, key, exchange string, noWait bool, args amqp091.Table) error
+}
+
+// rpcServer dispatches deliveries from the RPC queue to handlers
+type rpcServer struct {
+	config   *RPCConfig
+	ch       amqpChannel
+	handlers *HandlerRegistry
+	codecs   *codec.Registry
+	limiter  *RateLimiter
+	breakers *BreakerSet
+	metrics  *Metrics
+	tracer   *tracing.Tracer
+
+	// Admitted requests wait here for a worker
+	high chan job
+	low  chan job
+
+	// Admitted requests by delivery tag, until they are acked
+	mu       sync.Mutex
+	inflight map[uint64]*InFlightRequest
+
+	// Consumer state, see consumer.go
+	consumeMu   sync.Mutex
+	consumerTag string
+	prefetch    int
+	consuming   bool
+	served      chan struct{} // closed when the current serve loop returns
+}
+
+// InFlightRequest describes a request that was admitted but not yet acked
+type InFlightRequest struct {
+	CorrelationID string    `json:"correlation_id"`
+	Method        string    `json:"method"`
+	Priority      uint8     `json:"priority"`
+	State         string    `json:"state"` // "queued" or "processing"
+	Received      time.Time `json:"received"`
+	Age           string    `json:"age"`
+
+	cancel    func() // cancels the handler's context once processing
+	cancelled bool   // the caller sent a cancel
+}
+
+// job is an admitted request waiting for a worker
+type job struct {
+	ctx       context.Context // carries the receive span
+	d         amqp091.Delivery
+	name      string
+	handler   Handler
+	codec     codec.Codec
+	breaker   *CircuitBreaker
+	admission Admission
+}
+
+// newRPCServer creates a server with a worker pool of RPC.MaxWorkers
+func newRPCServer(config *RPCConfig, ch amqpChannel, handlers *HandlerRegistry, tracer *tracing.Tracer) *rpcServer {
+	// Prefetch bounds the unacked deliveries and SetPrefetch never raises
+	// it past MaxPrefetchCount, so serve never blocks on these
+	queued := max(config.QoS.MaxPrefetchCount, config.RPC.MaxWorkers)
+	s := &rpcServer{
+		config:   config,
+		ch:       ch,
+		handlers: handlers,
+		codecs:   codec.Default(),
+		breakers: NewBreakerSet(config),
+		metrics:  &Metrics{},
+		tracer:   tracer,
+		high:     make(chan job, queued),
+		low:      make(chan job, queued),
+		inflight: make(map[uint64]*InFlightRequest),
+
+		consumerTag: config.Consumer.Tag,
+		prefetch:    config.QoS.PrefetchCount,
+	}
+	if s.consumerTag == "" {
+		s.consumerTag = fmt.Sprintf("rpc_server-%d", time.Now().UnixNano())
+	}
+	if config.RateLimit.Enabled {
+		s.limiter = NewRateLimiter(config)
+	}
+	return s
+}
+
+// startWorkers starts MaxWorkers workers. ReservedWorkers of them only
+// take high priority requests so batch traffic cannot starve them.
+func (s *rpcServer) startWorkers() {
+	reserved := 0
+	if s.config.Queue.MaxPriority > 0 {
+		reserved = s.config.RPC.ReservedWorkers
+	}
+	for i := 0; i < s.config.RPC.MaxWorkers; i++ {
+		go s.worker(i < reserved)
+	}
+}
+
+// worker processes jobs, always preferring high priority ones
+func (s *rpcServer) worker(reserved bool) {
+	high, low := s.high, s.low
+	if reserved {
+		low = nil
+	}
+	for high != nil || low != nil {
+		var j job
+		var ok bool
+		select {
+		case j, ok = <-high:
+			if !ok {
+				high = nil
+				continue
+			}
+		default:
+			select {
+			case j, ok = <-high:
+				if !ok {
+					high = nil
+					continue
+				}
+			case j, ok = <-low:
+				if !ok {
+					low = nil
+					continue
+				}
+			}
+		}
+		s.process(j)
+	}
+}
+
+// isHighPriority reports whether d may use the reserved workers
+func (s *rpcServer) isHighPriority(d amqp091.Delivery) bool {
+	return s.config.Queue.MaxPriority > 0 && d.Priority >= s.config.RPC.HighPriority
+}
+
+// serve admits deliveries until msgs is closed
+func (s *rpcServer) serve(msgs <-chan amqp091.Delivery, done chan<- struct{}) {
+	defer close(done)
+
+	for d := range msgs {
+		s.metrics.RequestsTotal.Add(1)
+
+		// The receive span stays open until the request is answered
+		ctx, span := s.tracer.StartReceive(context.Background(), d)
+		if !s.admit(ctx, d) {
+			span.End()
+		}
+	}
+}
+
+// admit checks a delivery and queues it for a worker. It returns false
+// when the request was answered with an error instead.
+func (s *rpcServer) admit(ctx context.Context, d amqp091.Delivery) bool {
+	if d.ReplyTo == "" {
+		s.metrics.NoReplyToTotal.Add(1)
+		if s.config.RPC.NoReplyTo == NoReplyToReject {
+			log.Printf("Rejected request without ReplyTo (correlation id %q)", d.CorrelationId)
+			tracing.SpanFromContext(ctx).RecordError(errors.New("request without ReplyTo"))
+			d.Nack(false, false)
+			return false
+		}
+	}
+
+	// Reject over-limit callers before they take a worker
+	if s.limiter != nil {
+		key := rateLimitKey(d, s.config.RateLimit.KeySource, s.config.RateLimit.KeyHeader)
+		if ok, retryAfter := s.limiter.Allow(key); !ok {
+			log.Printf("Rate limited %q (retry after %s)", key, retryAfter)
+			s.metrics.RateLimitedTotal.Add(1)
+			s.rejectRateLimited(ctx, d, retryAfter)
+			return false
+		}
+	}
+
+	handler, name, ok := s.handlers.Lookup(d.Type)
+	if !ok {
+		log.Printf("Unknown method %q", name)
+		s.reject(ctx, d, ErrCodeUnknownMethod, fmt.Sprintf("unknown method %q", name), nil)
+		return false
+	}
+
+	// Undo ContentEncoding, refusing bodies that expand past the limit
+	if err := compress.Delivery(&d, s.config.Compression.MaxDecompressedSize); err != nil {
+		log.Printf("Rejected request: %v", err)
+		s.reject(ctx, d, ErrCodeBadEncoding, err.Error(), nil)
+		return false
+	}
+
+	c, err := s.codecs.Lookup(d.ContentType)
+	if err != nil {
+		log.Printf("Rejected request: %v", err)
+		s.rejectUnsupported(ctx, d, err)
+		return false
+	}
+
+	// Fail fast while the handler's circuit is open
+	breaker := s.breakers.Get(name)
+	admission, ok, retryAfter := breaker.Allow()
+	if !ok {
+		s.metrics.CircuitRejectedTotal.Add(1)
+		s.rejectCircuitOpen(ctx, d, name, retryAfter)
+		return false
+	}
+
+	s.track(d, name)
+	j := job{ctx: ctx, d: d, name: name, handler: handler, codec: c, breaker: breaker, admission: admission}
+	if s.isHighPriority(d) {
+		s.high <- j
+	} else {
+		s.low <- j
+	}
+	return true
+}
+
+// process runs the handler for one job and replies with the result
+func (s *rpcServer) process(j job) {
+	d, handler, c, breaker, admission := j.d, j.handler, j.codec, j.breaker, j.admission
+	defer s.untrack(d.DeliveryTag)
+	defer tracing.SpanFromContext(j.ctx).End()
+
+	spanCtx, span := s.tracer.Start(j.ctx, "handle "+j.name, tracing.KindServer)
+	defer span.End()
+
+	// Process with timeout; a cancel from the caller ends it early
+	ctx, cancel := context.WithTimeout(spanCtx, s.config.RPC.ProcessTimeout)
+	defer cancel()
+	if !s.markProcessing(d.DeliveryTag, cancel) {
+		log.Printf("Skipped cancelled request (correlation id %q)", d.CorrelationId)
+		s.skipCancelled(d, breaker, admission)
+		return
+	}
+
+	log.Printf("Received: %s", d.Body)
+
+	type result struct {
+		body []byte
+		err  error
+	}
+	resultCh := make(chan result, 1)
+
+	go func() {
+		// A panicking handler fails its own request, not the server
+		defer func() {
+			if p := recover(); p != nil {
+				resultCh <- result{nil, &panicError{value: p, stack: debug.Stack()}}
+			}
+		}()
+
+		decode := func(v any) error { return c.Unmarshal(d.Body, v) }
+		resp, err := handler(ctx, decode)
+		if err != nil {
+			resultCh <- result{nil, err}
+			return
+		}
+		body, err := c.Marshal(resp)
+		if err != nil {
+			err = fmt.Errorf("failed to encode response as %s: %w", c.ContentType(), err)
+		}
+		resultCh <- result{body, err}
+	}()
+
+	// Wait for response or timeout
+	select {
+	case r := <-resultCh:
+		if errors.Is(ctx.Err(), context.Canceled) {
+			// The caller is gone; the handler's answer, or its complaint
+			// about the cancelled context, goes nowhere
+			s.skipCancelled(d, breaker, admission)
+			return
+		}
+
+		// A request the handler couldn't decode says nothing about its health
+		breaker.Record(admission, r.err == nil || isBadRequest(r.err))
+
+		var perr *panicError
+		if errors.As(r.err, &perr) {
+			s.metrics.PanicsTotal.Add(1)
+			span.RecordError(perr)
+			log.Printf("Handler %q panicked (correlation id %q): %v\n%s",
+				j.name, d.CorrelationId, perr.value, perr.stack)
+			if err := s.sendError(spanCtx, d, ErrCodeInternal, "internal error", nil); err != nil {
+				log.Printf("Failed to send error response: %v", err)
+			}
+			// Dead-letter the poison request rather than redeliver it
+			d.Nack(false, false)
+			return
+		}
+
+		if r.err != nil {
+			s.metrics.ErrorsTotal.Add(1)
+			code := ErrCodeHandler
+			if isBadRequest(r.err) {
+				code = ErrCodeBadRequest
+			}
+			if err := s.sendError(spanCtx, d, code, r.err.Error(), nil); err != nil {
+				log.Printf("Failed to send error response: %v", err)
+			}
+		} else if d.ReplyTo != "" {
+			s.metrics.RepliesTotal.Add(1)
+			if err := s.sendReply(spanCtx, d, c.ContentType(), r.body); err != nil {
+				log.Printf("Failed to send response: %v", err)
+			}
+		}
+		d.Ack(false)
+
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.Canceled) {
+			span.RecordError(ctx.Err())
+			s.skipCancelled(d, breaker, admission)
+			return
+		}
+		breaker.Record(admission, false)
+		s.metrics.TimeoutsTotal.Add(1)
+		span.RecordError(ctx.Err())
+		log.Printf("Request timeout")
+		d.Nack(false, false)
+	}
+}
+
+// panicError carries a recovered handler panic and where it happened
+type panicError struct {
+	value any
+	stack []byte
+}
+
+func (e *panicError) Error() string { return fmt.Sprintf("handler panic: %v", e.value) }
+
+// declareDeadLetter declares the dead letter exchange and a queue bound to it
+func declareDeadLetter(ch *amqp091.Channel, config *RPCConfig) error {
+	err := ch.ExchangeDeclare(
+		config.Queue.DeadLetterExchange,
+		"fanout",
+		true,  // durable
+		false, // auto-deleted
+		false, // internal
+		false, // no-wait
+		nil,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = ch.QueueDeclare(config.Queue.DeadLetterQueue, true, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+	return ch.QueueBind(config.Queue.DeadLetterQueue, "", config.Queue.DeadLetterExchange, false, nil)
+}
+
+// track records an admitted request as queued
+func (s *rpcServer) track(d amqp091.Delivery, method string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inflight[d.DeliveryTag] = &InFlightRequest{
+		CorrelationID: d.CorrelationId,
+		Method:        method,
+		Priority:      d.Priority,
+		State:         "queued",
+		Received:      time.Now(),
+	}
+}
+
+// markProcessing records that a worker picked up the request and how to
+// cancel it. It returns false if the caller already cancelled it.
+func (s *rpcServer) markProcessing(tag uint64, cancel func()) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.inflight[tag]
+	if !ok {
+		return true
+	}
+	if r.cancelled {
+		return false
+	}
+	r.State = "processing"
+	r.cancel = cancel
+	return true
+}
+
+func (s *rpcServer) untrack(tag uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.inflight, tag)
+}
+
+// InFlight lists admitted requests, oldest first
+func (s *rpcServer) InFlight() []InFlightRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	list := make([]InFlightRequest, 0, len(s.inflight))
+	for _, r := range s.inflight {
+		req := *r
+		req.Age = now.Sub(r.Received).Round(time.Millisecond).String()
+		list = append(list, req)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Received.Before(list[j].Received)
+	})
+	return list
+}
@@ -0,0 +1,469 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Ashraful52038/RabbitMq/pkg/codec"
+	"github.com/Ashraful52038/RabbitMq/pkg/compress"
+	"github.com/Ashraful52038/RabbitMq/pkg/tracing"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// fakeChannel stands in for the broker channel. It records replies and
+// acknowledgements, and Consume hands out a deliveries channel that the
+// test feeds and Cancel closes.
+type fakeChannel struct {
+	mu         sync.Mutex
+	published  []fakePublish
+	acked      map[uint64]bool
+	nacked     map[uint64]bool // value is requeue
+	prefetch   int
+	deliveries chan amqp091.Delivery
+	returns    chan amqp091.Return
+}
+
+type fakePublish struct {
+	key       string
+	mandatory bool
+	msg       amqp091.Publishing
+}
+
+func newFakeChannel() *fakeChannel {
+	return &fakeChannel{acked: make(map[uint64]bool), nacked: make(map[uint64]bool)}
+}
+
+func (f *fakeChannel) Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp091.Table) (<-chan amqp091.Delivery, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.deliveries = make(chan amqp091.Delivery)
+	return f.deliveries, nil
+}
+
+func (f *fakeChannel) Cancel(consumer string, noWait bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	close(f.deliveries)
+	f.deliveries = nil
+	return nil
+}
+
+func (f *fakeChannel) Qos(prefetchCount, prefetchSize int, global bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.prefetch = prefetchCount
+	return nil
+}
+
+func (f *fakeChannel) Publish(exchange, key string, mandatory, immediate bool, msg amqp091.Publishing) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.published = append(f.published, fakePublish{key, mandatory, msg})
+	return nil
+}
+
+func (f *fakeChannel) NotifyReturn(c chan amqp091.Return) chan amqp091.Return {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.returns = c
+	return c
+}
+
+func (f *fakeChannel) ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp091.Table) error {
+	return nil
+}
+
+func (f *fakeChannel) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp091.Table) (amqp091.Queue, error) {
+	return amqp091.Queue{Name: name}, nil
+}
+
+func (f *fakeChannel) QueueBind(name, key, exchange string, noWait bool, args amqp091.Table) error {
+	return nil
+}
+
+// Ack, Nack and Reject make fakeChannel the deliveries' Acknowledger
+func (f *fakeChannel) Ack(tag uint64, multiple bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.acked[tag] = true
+	return nil
+}
+
+func (f *fakeChannel) Nack(tag uint64, multiple, requeue bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nacked[tag] = requeue
+	return nil
+}
+
+func (f *fakeChannel) Reject(tag uint64, requeue bool) error {
+	return f.Nack(tag, false, requeue)
+}
+
+// deliver hands d to the current consumer as the broker would
+func (f *fakeChannel) deliver(d amqp091.Delivery) {
+	f.mu.Lock()
+	deliveries := f.deliveries
+	f.mu.Unlock()
+	deliveries <- d
+}
+
+func (f *fakeChannel) isAcked(tag uint64) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.acked[tag]
+}
+
+// nacked reports whether tag was nacked and if it was requeued
+func (f *fakeChannel) isNacked(tag uint64) (nacked, requeue bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	requeue, nacked = f.nacked[tag]
+	return nacked, requeue
+}
+
+// replies returns what was published in answer to corrID
+func (f *fakeChannel) replies(corrID string) []fakePublish {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []fakePublish
+	for _, p := range f.published {
+		if p.msg.CorrelationId == corrID {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// testConfig is a valid config with a few workers and no rate limits
+func testConfig() *RPCConfig {
+	c := DefaultRPCConfig()
+	c.RPC.MaxWorkers = 2
+	c.RPC.ProcessTimeout = time.Second
+	c.QoS.PrefetchCount = 4
+	return c
+}
+
+func newTestServer(t *testing.T, c *RPCConfig, handlers *HandlerRegistry) (*rpcServer, *fakeChannel) {
+	t.Helper()
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	ch := newFakeChannel()
+	return newRPCServer(c, ch, handlers, tracing.NewTracer("test", nil)), ch
+}
+
+// request builds a delivery acknowledged through ch
+func request(ch *fakeChannel, tag uint64, method, body string) amqp091.Delivery {
+	return amqp091.Delivery{
+		Acknowledger:  ch,
+		DeliveryTag:   tag,
+		Type:          method,
+		ContentType:   codec.ContentTypeText,
+		CorrelationId: fmt.Sprint("corr-", tag),
+		ReplyTo:       "amq.gen-reply",
+		Body:          []byte(body),
+	}
+}
+
+// submit admits d the way serve does, under a receive span
+func submit(s *rpcServer, d amqp091.Delivery) bool {
+	ctx, _ := s.tracer.StartReceive(context.Background(), d)
+	return s.admit(ctx, d)
+}
+
+// waitFor polls cond for up to a second
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// echoHandlers registers "echo", which answers with its input
+func echoHandlers() *HandlerRegistry {
+	r := NewHandlerRegistry()
+	r.Register("echo", HandlerFunc(func(ctx context.Context, body string) (string, error) {
+		return body, nil
+	}))
+	return r
+}
+
+func TestWorkersPreferHighPriority(t *testing.T) {
+	c := testConfig()
+	c.RPC.MaxWorkers = 1
+	c.Queue.MaxPriority = 10
+	c.RPC.HighPriority = 5
+
+	var mu sync.Mutex
+	var order []string
+	r := NewHandlerRegistry()
+	r.Register("record", HandlerFunc(func(ctx context.Context, body string) (string, error) {
+		mu.Lock()
+		order = append(order, body)
+		mu.Unlock()
+		return body, nil
+	}))
+	s, ch := newTestServer(t, c, r)
+
+	// queue everything before a worker runs
+	for i, p := range []uint8{1, 1, 9, 1, 7} {
+		d := request(ch, uint64(i+1), "record", fmt.Sprint("p", p, "-", i+1))
+		d.Priority = p
+		submit(s, d)
+	}
+	s.startWorkers()
+	waitFor(t, "all requests", func() bool { return ch.isAcked(5) && ch.isAcked(4) })
+
+	mu.Lock()
+	defer mu.Unlock()
+	if got := fmt.Sprint(order); got != "[p9-3 p7-5 p1-1 p1-2 p1-4]" {
+		t.Fatalf("handled in order %s, want high priority first", got)
+	}
+}
+
+func TestReservedWorkersServeHighPriority(t *testing.T) {
+	c := testConfig()
+	c.RPC.MaxWorkers = 2
+	c.RPC.ReservedWorkers = 1
+	c.Queue.MaxPriority = 10
+	c.RPC.HighPriority = 5
+
+	release := make(chan struct{})
+	r := echoHandlers()
+	r.Register("slow", HandlerFunc(func(ctx context.Context, body string) (string, error) {
+		<-release
+		return body, nil
+	}))
+	s, ch := newTestServer(t, c, r)
+	s.startWorkers()
+	defer close(release)
+
+	// low priority work fills the unreserved worker and then waits
+	for tag := uint64(1); tag <= 2; tag++ {
+		submit(s, request(ch, tag, "slow", "batch"))
+	}
+	urgent := request(ch, 3, "echo", "urgent")
+	urgent.Priority = 9
+	submit(s, urgent)
+
+	waitFor(t, "high priority reply", func() bool { return ch.isAcked(3) })
+	if ch.isAcked(1) || ch.isAcked(2) {
+		t.Fatal("low priority requests finished while blocked")
+	}
+}
+
+func TestRaisedPrefetchNeverBlocksServe(t *testing.T) {
+	c := testConfig()
+	c.QoS.MaxPrefetchCount = 16
+
+	release := make(chan struct{})
+	r := NewHandlerRegistry()
+	r.Register("slow", HandlerFunc(func(ctx context.Context, body string) (string, error) {
+		<-release
+		return body, nil
+	}))
+	s, ch := newTestServer(t, c, r)
+	s.startWorkers()
+
+	if err := s.SetPrefetch(17); err == nil {
+		t.Fatal("SetPrefetch above MaxPrefetchCount succeeded")
+	}
+	if err := s.SetPrefetch(16); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Resume(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a full prefetch window while every worker is busy
+	for tag := uint64(1); tag <= 16; tag++ {
+		ch.deliver(request(ch, tag, "slow", "batch"))
+	}
+	paused := make(chan error, 1)
+	go func() { paused <- s.Pause() }()
+	select {
+	case err := <-paused:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Pause blocked behind a full job queue")
+	}
+
+	close(release)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := s.Drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for tag := uint64(1); tag <= 16; tag++ {
+		if !ch.isAcked(tag) {
+			t.Fatalf("request %d not answered after drain", tag)
+		}
+	}
+}
+
+func TestPanickingHandlerRepliesInternalError(t *testing.T) {
+	r := echoHandlers()
+	r.Register("explode", HandlerFunc(func(ctx context.Context, body string) (string, error) {
+		panic("boom")
+	}))
+	s, ch := newTestServer(t, testConfig(), r)
+	s.startWorkers()
+
+	submit(s, request(ch, 1, "explode", "x"))
+	waitFor(t, "poison request settled", func() bool { nacked, _ := ch.isNacked(1); return nacked })
+
+	// settled without a requeue, so the poison request is dead-lettered
+	// instead of coming back
+	if _, requeue := ch.isNacked(1); requeue || ch.isAcked(1) {
+		t.Fatalf("poison request requeued %v, acked %v", requeue, ch.isAcked(1))
+	}
+	replies := ch.replies("corr-1")
+	if len(replies) != 1 {
+		t.Fatalf("got %d replies, want 1", len(replies))
+	}
+	if p := replies[0]; p.key != "amq.gen-reply" || p.msg.Headers[ErrorCodeHeader] != ErrCodeInternal {
+		t.Fatalf("reply to %q with code %v, want %s", p.key, p.msg.Headers[ErrorCodeHeader], ErrCodeInternal)
+	}
+	if string(replies[0].msg.Body) != "internal error" {
+		t.Fatalf("reply body %q leaks the panic", replies[0].msg.Body)
+	}
+	if n := s.metrics.PanicsTotal.Load(); n != 1 {
+		t.Fatalf("PanicsTotal = %d, want 1", n)
+	}
+
+	// the worker survived
+	submit(s, request(ch, 2, "echo", "still here"))
+	waitFor(t, "next request", func() bool { return ch.isAcked(2) })
+}
+
+func TestRequestWithoutReplyTo(t *testing.T) {
+	for _, policy := range []string{NoReplyToReject, NoReplyToProcess} {
+		t.Run(policy, func(t *testing.T) {
+			c := testConfig()
+			c.RPC.NoReplyTo = policy
+			ran := make(chan string, 1)
+			r := NewHandlerRegistry()
+			r.Register("notify", HandlerFunc(func(ctx context.Context, body string) (string, error) {
+				ran <- body
+				return body, nil
+			}))
+			s, ch := newTestServer(t, c, r)
+			s.startWorkers()
+
+			d := request(ch, 1, "notify", "fire and forget")
+			d.ReplyTo = ""
+			admitted := submit(s, d)
+
+			if policy == NoReplyToReject {
+				nacked, requeue := ch.isNacked(1)
+				if admitted || !nacked || requeue {
+					t.Fatalf("admitted %v, nacked %v, requeued %v; want a dead-lettered request", admitted, nacked, requeue)
+				}
+				select {
+				case <-ran:
+					t.Fatal("handler ran for a rejected request")
+				default:
+				}
+			} else {
+				waitFor(t, "request processed", func() bool { return ch.isAcked(1) })
+				if got := <-ran; got != "fire and forget" {
+					t.Fatalf("handler got %q", got)
+				}
+			}
+			if n := len(ch.replies("corr-1")); n != 0 {
+				t.Fatalf("published %d replies to an empty ReplyTo", n)
+			}
+			if n := s.metrics.NoReplyToTotal.Load(); n != 1 {
+				t.Fatalf("NoReplyToTotal = %d, want 1", n)
+			}
+		})
+	}
+}
+
+// logBuffer collects log output written from other goroutines
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestReturnedRepliesAreCounted(t *testing.T) {
+	logs := &logBuffer{}
+	log.SetOutput(logs)
+	defer log.SetOutput(os.Stderr)
+
+	s, ch := newTestServer(t, testConfig(), echoHandlers())
+	s.watchReturns()
+	s.startWorkers()
+
+	submit(s, request(ch, 1, "echo", "hello"))
+	waitFor(t, "reply", func() bool { return ch.isAcked(1) })
+
+	// the reply queue vanished, so the broker hands the reply back
+	reply := ch.replies("corr-1")[0]
+	ch.returns <- amqp091.Return{
+		ReplyCode:     312,
+		ReplyText:     "NO_ROUTE",
+		RoutingKey:    reply.key,
+		CorrelationId: reply.msg.CorrelationId,
+	}
+	close(ch.returns)
+	waitFor(t, "returned reply logged", func() bool {
+		return strings.Contains(logs.String(), `312 NO_ROUTE (correlation id "corr-1", reply queue "amq.gen-reply")`)
+	})
+	if n := s.metrics.ReturnedRepliesTotal.Load(); n != 1 {
+		t.Fatalf("ReturnedRepliesTotal = %d, want 1", n)
+	}
+	if !reply.mandatory {
+		t.Fatal("reply published without mandatory, so it could not be returned")
+	}
+}
+
+func TestRepliesCompressedOnlyWhenAccepted(t *testing.T) {
+	s, ch := newTestServer(t, testConfig(), echoHandlers())
+	s.startWorkers()
+	large := strings.Repeat("compressible ", 200)
+
+	// a caller that predates compression, then one that accepts zstd only
+	submit(s, request(ch, 1, "echo", large))
+	accepting := request(ch, 2, "echo", large)
+	accepting.Headers = amqp091.Table{compress.AcceptEncodingHeader: "zstd"}
+	submit(s, accepting)
+
+	waitFor(t, "both replies", func() bool { return ch.isAcked(1) && ch.isAcked(2) })
+	for corrID, want := range map[string]string{"corr-1": "", "corr-2": compress.Zstd} {
+		r := ch.replies(corrID)
+		if len(r) != 1 || r[0].msg.ContentEncoding != want {
+			t.Fatalf("%s: replies %+v, want one with encoding %q", corrID, r, want)
+		}
+		d := amqp091.Delivery{Body: r[0].msg.Body, ContentEncoding: r[0].msg.ContentEncoding}
+		if err := compress.Delivery(&d, 0); err != nil || string(d.Body) != large {
+			t.Fatalf("%s: reply body does not decode: %v", corrID, err)
+		}
+	}
+}